@@ -0,0 +1,16 @@
+package ccm
+
+import (
+	"context"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// waitForAction waits until all actions finished and returns the error of the first one that failed.
+func waitForAction(ctx context.Context, client *hcloud.Client, actions ...*hcloud.Action) error {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	_, errCh := client.Action.WatchOverallProgress(ctx, actions)
+	return <-errCh
+}
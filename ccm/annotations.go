@@ -0,0 +1,232 @@
+package ccm
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotations on Services of type LoadBalancer that configure the hcloud Load Balancer.
+const (
+	annotationPrefix = "load-balancer.hetzner.cloud/"
+
+	// annotationLBType is the name of the Load Balancer type, e.g. "lb21". Changing it changes the type of the existing
+	// Load Balancer in place.
+	annotationLBType = annotationPrefix + "type"
+
+	// annotationLBLocation is the name of the location the Load Balancer is created in, e.g. "nbg1". Mutually exclusive
+	// with annotationLBNetworkZone. Only applied on create.
+	annotationLBLocation = annotationPrefix + "location"
+
+	// annotationLBNetworkZone is the name of the network zone the Load Balancer is created in, e.g. "eu-central".
+	// Mutually exclusive with annotationLBLocation. Only applied on create.
+	annotationLBNetworkZone = annotationPrefix + "network-zone"
+
+	// annotationLBAlgorithmType is the algorithm used to distribute connections, either "round_robin" or
+	// "least_connections". Defaults to "round_robin".
+	annotationLBAlgorithmType = annotationPrefix + "algorithm-type"
+
+	// annotationLBName overrides the name of the Load Balancer built from the name template in the cloud config.
+	// Changing it renames the existing Load Balancer.
+	annotationLBName = annotationPrefix + "name"
+
+	// annotationLBExisting is the ID or name of an existing Load Balancer that is used for the Service. The CCM
+	// reconciles its services and targets, but never creates or deletes it. When the Service is deleted, only the
+	// services and targets added by the CCM are removed.
+	annotationLBExisting = annotationPrefix + "existing"
+
+	// annotationExistingState is set by the CCM on Services with annotationLBExisting. It records the ID of the existing
+	// Load Balancer and the listen ports, servers and IPs the CCM added to it as JSON. It must not be changed by users.
+	annotationExistingState = annotationPrefix + "existing-state"
+
+	// annotationLBProtected enables or disables the delete protection of the Load Balancer. Protected Load Balancers are
+	// kept when the Service is deleted, only their services and targets are removed. If the annotation is removed, the
+	// protection is disabled again when the CCM enabled it, and left as it is otherwise.
+	annotationLBProtected = annotationPrefix + "protected"
+
+	// annotationPublicIPv4 and annotationPublicIPv6 are the public IPs the Load Balancer must have, e.g. the IPs of a
+	// retained Load Balancer that customers have in their DNS. hcloud assigns the IPs of new Load Balancers itself and
+	// cannot create one with given IPs, so the CCM never creates a Load Balancer for a Service with these annotations
+	// and does not record the IPs of a Load Balancer for later. Instead it adopts the Load Balancer with the IPs that
+	// this cluster retained after its Service was deleted (see annotationLBProtected and the retain option), and fails if
+	// there is none or the IPs do not match. Load Balancers of other clusters or not created by the CCM are never adopted.
+	annotationPublicIPv4 = annotationPrefix + "public-ipv4"
+	annotationPublicIPv6 = annotationPrefix + "public-ipv6"
+
+	// annotationDisablePublicNetwork disables the public interface of the Load Balancer, so it is only reachable
+	// through the private network. The status of the Service then reports the private IP.
+	annotationDisablePublicNetwork = annotationPrefix + "disable-public-network"
+
+	// annotationPrivateIPv4 is the IP the Load Balancer gets in the private network. Only applied when the Load
+	// Balancer is attached to the network.
+	annotationPrivateIPv4 = annotationPrefix + "private-ipv4"
+
+	// annotationIPFamilies is a comma-separated list of the IP families published in the status of the Service, "IPv4"
+	// and/or "IPv6". Defaults to the families in spec.ipFamilies, or both if it is empty.
+	annotationIPFamilies = annotationPrefix + "ip-families"
+
+	// annotationUseHostname publishes the DNS PTR hostname of the Load Balancer instead of its IPs in the status of the
+	// Service.
+	annotationUseHostname = annotationPrefix + "use-hostname"
+
+	// annotationIPMode overrides the ipMode of the ingress IPs in the status of the Service, either "VIP" or "Proxy".
+	// Defaults to "Proxy" if any service of the Load Balancer uses the PROXY protocol or HTTP(S), and to "VIP"
+	// otherwise. "VIP" is published as an unset ipMode, which means the same. The API server only keeps the ipMode if
+	// the LoadBalancerIPMode feature gate is enabled, which is off by default in Kubernetes 1.29.
+	annotationIPMode = annotationPrefix + "ip-mode"
+
+	// annotationHealthCheckProtocol is the protocol of the health check, either "tcp" or "http". Defaults to "tcp", or
+	// to "http" if the Service uses externalTrafficPolicy Local.
+	annotationHealthCheckProtocol = annotationPrefix + "health-check-protocol"
+
+	// annotationHealthCheckPort is the port the health check connects to on the nodes. Defaults to the NodePort, or to
+	// spec.healthCheckNodePort if the Service uses externalTrafficPolicy Local.
+	annotationHealthCheckPort = annotationPrefix + "health-check-port"
+
+	// annotationHealthCheckInterval is the time between two health checks, e.g. "15s".
+	annotationHealthCheckInterval = annotationPrefix + "health-check-interval"
+
+	// annotationHealthCheckTimeout is the time after which a single health check fails, e.g. "10s".
+	annotationHealthCheckTimeout = annotationPrefix + "health-check-timeout"
+
+	// annotationHealthCheckRetries is the number of failed health checks before a target is considered unhealthy.
+	annotationHealthCheckRetries = annotationPrefix + "health-check-retries"
+
+	// annotationHealthCheckHTTPPath is the request path of HTTP health checks. Defaults to "/", or to "/healthz" if the
+	// Service uses externalTrafficPolicy Local.
+	annotationHealthCheckHTTPPath = annotationPrefix + "health-check-http-path"
+
+	// annotationHealthCheckHTTPStatusCodes is a comma-separated list of status codes that HTTP health checks accept,
+	// e.g. "2??,301".
+	annotationHealthCheckHTTPStatusCodes = annotationPrefix + "health-check-http-status-codes"
+
+	// annotationProtocol is the protocol of the Load Balancer services, one of "tcp", "http" or "https". It is either a
+	// single protocol for all ports, or a comma-separated list of "port:protocol" pairs, e.g. "tcp,443:https". An
+	// entry without a port sets the protocol for all other ports. Defaults to "tcp".
+	annotationProtocol = annotationPrefix + "protocol"
+
+	// annotationHTTPCertificates is a comma-separated list of IDs or names of existing certificates used by HTTPS
+	// services.
+	annotationHTTPCertificates = annotationPrefix + "http-certificates"
+
+	// annotationHTTPManagedCertificateDomains is a comma-separated list of domains. The CCM requests a managed Let's
+	// Encrypt certificate for them and uses it for HTTPS services.
+	annotationHTTPManagedCertificateDomains = annotationPrefix + "http-managed-certificate-domains"
+
+	// annotationHTTPStickySessions enables sticky sessions for HTTP(S) services.
+	annotationHTTPStickySessions = annotationPrefix + "http-sticky-sessions"
+
+	// annotationHTTPCookieName is the name of the cookie used for sticky sessions.
+	annotationHTTPCookieName = annotationPrefix + "http-cookie-name"
+
+	// annotationHTTPCookieLifetime is the lifetime of the cookie used for sticky sessions, e.g. "5m".
+	annotationHTTPCookieLifetime = annotationPrefix + "http-cookie-lifetime"
+
+	// annotationHTTPRedirectHTTP redirects HTTP requests on port 80 to HTTPS services.
+	annotationHTTPRedirectHTTP = annotationPrefix + "http-redirect-http"
+
+	// annotationUsesProxyProtocol enables the PROXY protocol towards the targets. It is either "true" or "false" for
+	// all ports or a comma-separated list of ports, e.g. "80,443".
+	annotationUsesProxyProtocol = annotationPrefix + "uses-proxyprotocol"
+
+	// annotationTargetType is how the nodes are added as targets, either "server" or "label-selector". With "server"
+	// every node is added as a single server target. With "label-selector" the CCM labels the servers of the nodes and
+	// adds a single label selector target, so adding or removing nodes only changes the labels of servers. Defaults to
+	// "server".
+	annotationTargetType = annotationPrefix + "target-type"
+
+	// annotationTargetLabelSelector is an additional hcloud label selector for the label selector target, e.g.
+	// "pool=workers". It is combined with the cluster label the CCM sets on the servers.
+	annotationTargetLabelSelector = annotationPrefix + "target-label-selector"
+
+	// annotationUsePrivateIP makes the Load Balancer reach its server targets through their IPs in the private network
+	// instead of their public IPs. Defaults to the usePrivateIP setting of the cloud config, or to true if a network is
+	// configured. Targets with a different setting are recreated.
+	annotationUsePrivateIP = annotationPrefix + "use-private-ip"
+
+	// annotationNodeSelector is a Kubernetes label selector for the nodes that are added as targets, e.g.
+	// "!node-role.kubernetes.io/control-plane" or "pool=ingress". Nodes with the label
+	// node.kubernetes.io/exclude-from-external-load-balancers are never added. Not supported with the target type
+	// "label-selector", use annotationTargetLabelSelector instead.
+	annotationNodeSelector = annotationPrefix + "node-selector"
+
+	// annotationTargetsWithEndpointsOnly only adds the nodes that host ready endpoints of the Service as targets. It is
+	// only used with externalTrafficPolicy Local, where traffic to nodes without endpoints is dropped. The targets are
+	// updated whenever the EndpointSlices of the Service change. Not supported with the target type "label-selector".
+	annotationTargetsWithEndpointsOnly = annotationPrefix + "targets-with-endpoints-only"
+)
+
+// getAnnotation returns the value of the annotation and whether it was set to a non-empty value.
+func getAnnotation(service *v1.Service, key string) (string, bool) {
+	value, ok := service.Annotations[key]
+	if !ok || value == "" {
+		return "", false
+	}
+
+	return value, true
+}
+
+// getAnnotationBool parses the annotation as a boolean. It returns false if the annotation is not set.
+func getAnnotationBool(service *v1.Service, key string) (bool, bool, error) {
+	value, ok := getAnnotation(service, key)
+	if !ok {
+		return false, false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, false, fmt.Errorf("annotation %s: unable to parse boolean: %w", key, err)
+	}
+
+	return b, true, nil
+}
+
+// getAnnotationInt parses the annotation as an integer. It returns false if the annotation is not set.
+func getAnnotationInt(service *v1.Service, key string) (int, bool, error) {
+	value, ok := getAnnotation(service, key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("annotation %s: unable to parse integer: %w", key, err)
+	}
+
+	return i, true, nil
+}
+
+// getAnnotationDuration parses the annotation as a duration, e.g. "15s". It returns false if the annotation is not
+// set.
+func getAnnotationDuration(service *v1.Service, key string) (time.Duration, bool, error) {
+	value, ok := getAnnotation(service, key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("annotation %s: unable to parse duration: %w", key, err)
+	}
+
+	return d, true, nil
+}
+
+// getAnnotationStringSlice splits the comma-separated annotation. It returns false if the annotation is not set.
+func getAnnotationStringSlice(service *v1.Service, key string) ([]string, bool) {
+	value, ok := getAnnotation(service, key)
+	if !ok {
+		return nil, false
+	}
+
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values, true
+}
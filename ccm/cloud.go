@@ -1,11 +1,19 @@
 package ccm
 
 import (
+	"context"
+	"fmt"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
 	"os"
-	"strconv"
 )
 
 const (
@@ -13,14 +21,76 @@ const (
 )
 
 type CloudProvider struct {
-	client    *hcloud.Client
+	client *hcloud.Client
+	config Config
+
+	// networkID is 0 if the cluster runs without a private network.
 	networkID int64
+
+	// recorder emits events on Kubernetes objects. It is nil until Initialize was called.
+	recorder record.EventRecorder
+	// kubeClient reads and updates Services. It is nil until Initialize was called.
+	kubeClient kubernetes.Interface
+	// endpoints watches the EndpointSlices of Services. It is nil until Initialize was called.
+	endpoints *endpointsWatcher
+	// locks is shared by all copies of LoadBalancer and the endpoints watcher.
+	locks *serviceLocks
 }
 
-func (c CloudProvider) Initialize(_ cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {}
+func (c *CloudProvider) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+	client := clientBuilder.ClientOrDie(providerName)
+	c.kubeClient = client
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	c.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: providerName})
+
+	go func() {
+		<-stop
+		broadcaster.Shutdown()
+	}()
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+
+	if c.config.Features.LoadBalancers {
+		endpoints, err := newEndpointsWatcher(factory)
+		if err != nil {
+			klog.ErrorS(err, "unable to start endpoints watcher, targets are not limited to nodes with endpoints")
+		} else {
+			c.endpoints = endpoints
+
+			lb, _ := c.LoadBalancer()
+			go endpoints.run(lb.(LoadBalancer), stop)
+		}
+	}
+
+	if c.config.Features.Firewall {
+		firewall, err := newFirewallController(c.client, c.config.Firewall.Name, c.networkID, c.config.ClusterName, factory)
+		if err != nil {
+			klog.ErrorS(err, "unable to start firewall controller")
+		} else {
+			go firewall.run(stop)
+		}
+	}
+
+	factory.Start(stop)
+}
 
 func (c CloudProvider) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
-	return LoadBalancer{client: c.client, networkID: c.networkID}, true
+	if !c.config.Features.LoadBalancers {
+		return nil, false
+	}
+
+	return LoadBalancer{
+		client:      c.client,
+		networkID:   c.networkID,
+		config:      c.config.LoadBalancer,
+		recorder:    c.recorder,
+		kubeClient:  c.kubeClient,
+		endpoints:   c.endpoints,
+		locks:       c.locks,
+		clusterName: c.config.ClusterName,
+	}, true
 }
 
 func (c CloudProvider) Instances() (cloudprovider.Instances, bool) {
@@ -40,6 +110,11 @@ func (c CloudProvider) Clusters() (cloudprovider.Clusters, bool) {
 }
 
 func (c CloudProvider) Routes() (cloudprovider.Routes, bool) {
+	if !c.config.Features.Routes || c.networkID == 0 {
+		// Routes can only be configured in a private network
+		return nil, false
+	}
+
 	return &Routes{
 		client:    c.client,
 		networkID: c.networkID,
@@ -54,24 +129,57 @@ func (c CloudProvider) HasClusterID() bool {
 	return false
 }
 
-func newCloud(_ io.Reader) (cloudprovider.Interface, error) {
+func newCloud(config io.Reader) (cloudprovider.Interface, error) {
+	cfg, err := readConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
 	options := []hcloud.ClientOption{
-		hcloud.WithToken(os.Getenv("HCLOUD_TOKEN")),
+		hcloud.WithToken(cfg.Token),
 		hcloud.WithApplication("ccm-from-scratch", ""),
 	}
 
-	if os.Getenv("HCLOUD_DEBUG") != "" {
+	if cfg.Endpoint != "" {
+		options = append(options, hcloud.WithEndpoint(cfg.Endpoint))
+	}
+
+	if cfg.Debug {
 		options = append(options, hcloud.WithDebugWriter(os.Stderr))
 	}
 
 	client := hcloud.NewClient(options...)
 
-	networkID, err := strconv.ParseInt(os.Getenv("HCLOUD_NETWORK"), 10, 64)
+	networkID, err := resolveNetworkID(context.Background(), client, cfg.Network)
 	if err != nil {
 		return nil, err
 	}
 
-	return CloudProvider{client: client, networkID: networkID}, nil
+	return &CloudProvider{
+		client:    client,
+		config:    cfg,
+		networkID: networkID,
+		locks:     newServiceLocks(),
+	}, nil
+}
+
+// resolveNetworkID looks up the network by name if no ID was configured. It
+// returns 0 if no network is configured.
+func resolveNetworkID(ctx context.Context, client *hcloud.Client, network NetworkConfig) (int64, error) {
+	if network.ID != 0 || network.Name == "" {
+		return network.ID, nil
+	}
+
+	result, _, err := client.Network.GetByName(ctx, network.Name)
+	if err != nil {
+		return 0, fmt.Errorf("unable to look up network: %w", err)
+	}
+
+	if result == nil {
+		return 0, fmt.Errorf("network not found: Name=%s", network.Name)
+	}
+
+	return result.ID, nil
 }
 
 func init() {
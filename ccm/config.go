@@ -0,0 +1,327 @@
+package ccm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+const (
+	configVersionV1 = "v1"
+
+	envToken                = "HCLOUD_TOKEN"
+	envTokenFile            = "HCLOUD_TOKEN_FILE"
+	envEndpoint             = "HCLOUD_ENDPOINT"
+	envDebug                = "HCLOUD_DEBUG"
+	envClusterName          = "HCLOUD_CLUSTER_NAME"
+	envNetwork              = "HCLOUD_NETWORK"
+	envNetworkID            = "HCLOUD_NETWORK_ID"
+	envNetworkName          = "HCLOUD_NETWORK_NAME"
+	envLoadBalancerType     = "HCLOUD_LOAD_BALANCER_TYPE"
+	envLoadBalancerLocation = "HCLOUD_LOAD_BALANCER_LOCATION"
+	envLoadBalancerZone     = "HCLOUD_LOAD_BALANCER_NETWORK_ZONE"
+	envLoadBalancerPrivate  = "HCLOUD_LOAD_BALANCER_USE_PRIVATE_IP"
+	envLoadBalancerRetain   = "HCLOUD_LOAD_BALANCER_RETAIN"
+	envLoadBalancersEnabled = "HCLOUD_LOAD_BALANCERS_ENABLED"
+	envRoutesEnabled        = "HCLOUD_ROUTES_ENABLED"
+	envFirewallEnabled      = "HCLOUD_FIREWALL_ENABLED"
+	envFirewallName         = "HCLOUD_FIREWALL_NAME"
+)
+
+// Config is the content of the file passed with --cloud-config. It can be written as YAML or JSON. Every field can also
+// be set through an environment variable, which takes precedence over the value from the file.
+type Config struct {
+	// Version of the config format, currently only "v1" is supported.
+	Version string `json:"version"`
+
+	// Token for the Hetzner Cloud API. Either Token or TokenFile must be set.
+	Token string `json:"token"`
+	// TokenFile is the path to a file containing the API token.
+	TokenFile string `json:"tokenFile"`
+	// Endpoint overrides the URL of the Hetzner Cloud API.
+	Endpoint string `json:"endpoint"`
+	// Debug writes all API requests and responses to stderr.
+	Debug bool `json:"debug"`
+	// ClusterName must match --cluster-name. It is used by the controllers that run outside of the service controller,
+	// which only passes the cluster name to its own calls. Defaults to "kubernetes", the default of --cluster-name.
+	ClusterName string `json:"clusterName"`
+
+	Network      NetworkConfig      `json:"network"`
+	LoadBalancer LoadBalancerConfig `json:"loadBalancer"`
+	Firewall     FirewallConfig     `json:"firewall"`
+	Features     FeaturesConfig     `json:"features"`
+}
+
+// NetworkConfig references the private network the cluster runs in, either by ID or by name. If neither is set, the
+// CCM runs in public-only mode.
+type NetworkConfig struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// LoadBalancerConfig holds the defaults for load balancers created by the CCM. They can be overridden per Service
+// through annotations.
+type LoadBalancerConfig struct {
+	// NameTemplate is used to build the names of load balancers. It supports the placeholders {{cluster}},
+	// {{namespace}}, {{name}} and {{uid}}.
+	NameTemplate string `json:"nameTemplate"`
+
+	Type string `json:"type"`
+	// Location and NetworkZone are mutually exclusive. If neither is set, load balancers are created in fsn1.
+	Location    string `json:"location"`
+	NetworkZone string `json:"networkZone"`
+
+	// UsePrivateIP makes the Load Balancer reach its targets through the private network. Defaults to true if a
+	// network is configured.
+	UsePrivateIP *bool `json:"usePrivateIP"`
+
+	// Retain keeps Load Balancers when their Service is deleted, only their services and targets are removed.
+	Retain bool `json:"retain"`
+}
+
+// FirewallConfig configures the Firewall the CCM applies to the servers of all nodes, if the firewall feature is
+// enabled.
+type FirewallConfig struct {
+	// Name of the Firewall owned by the CCM. It is created with the label ccm-from-scratch/cluster if it does not
+	// exist. An existing Firewall without the label of the cluster is not used.
+	Name string `json:"name"`
+}
+
+// FeaturesConfig toggles the optional controllers of the CCM.
+type FeaturesConfig struct {
+	LoadBalancers bool `json:"loadBalancers"`
+	Routes        bool `json:"routes"`
+	// Firewall only allows the NodePorts of Services of type LoadBalancer from their Load Balancers. Disabled by
+	// default. Once applied, the Firewall drops all other public inbound traffic to the nodes, including SSH, the
+	// Kubernetes API and the kubelet, unless another Firewall on the servers allows it.
+	Firewall bool `json:"firewall"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Version:     configVersionV1,
+		ClusterName: "kubernetes",
+		LoadBalancer: LoadBalancerConfig{
+			NameTemplate: defaultLBNameTemplate,
+			Type:         "lb11",
+		},
+		Firewall: FirewallConfig{
+			Name: "ccm-from-scratch-nodes",
+		},
+		Features: FeaturesConfig{
+			LoadBalancers: true,
+			Routes:        true,
+		},
+	}
+}
+
+// readConfig parses the cloud config from r, applies the environment overrides and validates the result. r may be nil
+// if no config file was passed.
+func readConfig(r io.Reader) (Config, error) {
+	cfg := defaultConfig()
+
+	if r != nil {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to read cloud config: %w", err)
+		}
+
+		if err = yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("unable to parse cloud config: %w", err)
+		}
+	}
+
+	envErr := cfg.applyEnv()
+	tokenErr := cfg.resolveTokenFile()
+	cfg.applyDefaults()
+
+	if err := errors.Join(envErr, tokenErr, cfg.validate()); err != nil {
+		return Config{}, fmt.Errorf("invalid cloud config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// applyEnv overrides the values from the config file with the environment variables that are set.
+func (c *Config) applyEnv() error {
+	var errs []error
+
+	if v := os.Getenv(envToken); v != "" {
+		c.Token = v
+		c.TokenFile = ""
+	}
+	if v := os.Getenv(envTokenFile); v != "" {
+		c.Token = ""
+		c.TokenFile = v
+	}
+	if v := os.Getenv(envEndpoint); v != "" {
+		c.Endpoint = v
+	}
+	if os.Getenv(envDebug) != "" {
+		c.Debug = true
+	}
+	if v := os.Getenv(envClusterName); v != "" {
+		c.ClusterName = v
+	}
+	if v := os.Getenv(envNetwork); v != "" {
+		// HCLOUD_NETWORK takes an ID or a name, so everything that parses as an integer is an ID. Networks with a purely
+		// numeric name have to be set through HCLOUD_NETWORK_NAME.
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			c.Network = NetworkConfig{ID: id}
+		} else {
+			c.Network = NetworkConfig{Name: v}
+		}
+	}
+	if v, w := os.Getenv(envNetworkID), os.Getenv(envNetworkName); v != "" || w != "" {
+		// Setting both is reported by validate
+		c.Network = NetworkConfig{Name: w}
+		if v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envNetworkID, err))
+			}
+			c.Network.ID = id
+		}
+	}
+	if v := os.Getenv(envLoadBalancerType); v != "" {
+		c.LoadBalancer.Type = v
+	}
+	if v := os.Getenv(envLoadBalancerLocation); v != "" {
+		c.LoadBalancer.Location = v
+		c.LoadBalancer.NetworkZone = ""
+	}
+	if v := os.Getenv(envLoadBalancerZone); v != "" {
+		c.LoadBalancer.Location = ""
+		c.LoadBalancer.NetworkZone = v
+	}
+	if v := os.Getenv(envLoadBalancerPrivate); v != "" {
+		usePrivateIP, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envLoadBalancerPrivate, err))
+		} else {
+			c.LoadBalancer.UsePrivateIP = &usePrivateIP
+		}
+	}
+	if v := os.Getenv(envLoadBalancerRetain); v != "" {
+		retain, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envLoadBalancerRetain, err))
+		} else {
+			c.LoadBalancer.Retain = retain
+		}
+	}
+	if v := os.Getenv(envLoadBalancersEnabled); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envLoadBalancersEnabled, err))
+		} else {
+			c.Features.LoadBalancers = enabled
+		}
+	}
+	if v := os.Getenv(envRoutesEnabled); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envRoutesEnabled, err))
+		} else {
+			c.Features.Routes = enabled
+		}
+	}
+
+	if v := os.Getenv(envFirewallEnabled); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envFirewallEnabled, err))
+		} else {
+			c.Features.Firewall = enabled
+		}
+	}
+	if v := os.Getenv(envFirewallName); v != "" {
+		c.Firewall.Name = v
+	}
+
+	return errors.Join(errs...)
+}
+
+// applyDefaults sets the defaults that depend on other fields.
+func (c *Config) applyDefaults() {
+	if c.LoadBalancer.Location == "" && c.LoadBalancer.NetworkZone == "" {
+		c.LoadBalancer.Location = "fsn1"
+	}
+}
+
+func (c *Config) resolveTokenFile() error {
+	if c.Token != "" || c.TokenFile == "" {
+		return nil
+	}
+
+	token, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return fmt.Errorf("tokenFile: unable to read token: %w", err)
+	}
+
+	c.Token = strings.TrimSpace(string(token))
+	if c.Token == "" {
+		return fmt.Errorf("tokenFile: %s is empty", c.TokenFile)
+	}
+	return nil
+}
+
+// validate returns an error listing every invalid field of the config.
+func (c *Config) validate() error {
+	var errs []error
+
+	if c.Version != configVersionV1 {
+		errs = append(errs, fmt.Errorf("version: unsupported version %q, expected %q", c.Version, configVersionV1))
+	}
+
+	if c.Token == "" && c.TokenFile == "" {
+		errs = append(errs, errors.New("token: must be set, either directly or through tokenFile"))
+	}
+
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("endpoint: not a valid URL: %q", c.Endpoint))
+		}
+	}
+
+	if c.ClusterName == "" {
+		errs = append(errs, errors.New("clusterName: must not be empty"))
+	}
+
+	switch {
+	case c.Network.ID != 0 && c.Network.Name != "":
+		errs = append(errs, errors.New("network: only one of id and name may be set"))
+	case c.Network.ID < 0:
+		errs = append(errs, fmt.Errorf("network.id: must be positive, got %d", c.Network.ID))
+	}
+
+	if c.LoadBalancer.NameTemplate == "" {
+		errs = append(errs, errors.New("loadBalancer.nameTemplate: must not be empty"))
+	} else if err := validateLBNameTemplate(c.LoadBalancer.NameTemplate); err != nil {
+		errs = append(errs, fmt.Errorf("loadBalancer.nameTemplate: %w", err))
+	}
+
+	if c.LoadBalancer.Type == "" {
+		errs = append(errs, errors.New("loadBalancer.type: must not be empty"))
+	}
+	if c.LoadBalancer.Location != "" && c.LoadBalancer.NetworkZone != "" {
+		errs = append(errs, errors.New("loadBalancer: only one of location and networkZone may be set"))
+	}
+	if c.LoadBalancer.UsePrivateIP != nil && *c.LoadBalancer.UsePrivateIP && c.Network.ID == 0 && c.Network.Name == "" {
+		errs = append(errs, errors.New("loadBalancer.usePrivateIP: requires a network"))
+	}
+
+	if c.Features.Firewall && c.Firewall.Name == "" {
+		errs = append(errs, errors.New("firewall.name: must not be empty"))
+	}
+	if c.Features.Firewall && !c.Features.LoadBalancers {
+		errs = append(errs, errors.New("features.firewall: requires features.loadBalancers"))
+	}
+
+	return errors.Join(errs...)
+}
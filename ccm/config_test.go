@@ -0,0 +1,173 @@
+package ccm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		check  func(t *testing.T, cfg Config)
+	}{
+		{
+			name:   "defaults",
+			config: "version: v1\ntoken: file-token\n",
+			check: func(t *testing.T, cfg Config) {
+				if cfg.LoadBalancer.Type != "lb11" || cfg.LoadBalancer.Location != "fsn1" {
+					t.Errorf("expected default type lb11 in fsn1, got %s in %s", cfg.LoadBalancer.Type, cfg.LoadBalancer.Location)
+				}
+				if !cfg.Features.LoadBalancers || !cfg.Features.Routes || cfg.Features.Firewall {
+					t.Errorf("unexpected default features: %+v", cfg.Features)
+				}
+				if cfg.ClusterName != "kubernetes" {
+					t.Errorf("expected default cluster name kubernetes, got %q", cfg.ClusterName)
+				}
+			},
+		},
+		{
+			name:   "env takes precedence over file",
+			config: "version: v1\ntoken: file-token\nnetwork:\n  name: file-network\nloadBalancer:\n  type: lb21\n",
+			env: map[string]string{
+				envToken:            "env-token",
+				envNetwork:          "42",
+				envLoadBalancerType: "lb31",
+			},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Token != "env-token" {
+					t.Errorf("expected token from env, got %q", cfg.Token)
+				}
+				if cfg.Network != (NetworkConfig{ID: 42}) {
+					t.Errorf("expected network ID 42 from env, got %+v", cfg.Network)
+				}
+				if cfg.LoadBalancer.Type != "lb31" {
+					t.Errorf("expected type from env, got %q", cfg.LoadBalancer.Type)
+				}
+			},
+		},
+		{
+			name:   "env network name",
+			config: "version: v1\ntoken: file-token\nnetwork:\n  id: 1\n",
+			env:    map[string]string{envNetwork: "my-network"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Network != (NetworkConfig{Name: "my-network"}) {
+					t.Errorf("expected network name from env, got %+v", cfg.Network)
+				}
+			},
+		},
+		{
+			name:   "env numeric network name",
+			config: "version: v1\ntoken: file-token\nnetwork:\n  id: 1\n",
+			env:    map[string]string{envNetwork: "42", envNetworkName: "2024"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Network != (NetworkConfig{Name: "2024"}) {
+					t.Errorf("expected network name 2024 from env, got %+v", cfg.Network)
+				}
+			},
+		},
+		{
+			name:   "env network zone replaces file location",
+			config: "version: v1\ntoken: file-token\nloadBalancer:\n  location: nbg1\n",
+			env:    map[string]string{envLoadBalancerZone: "eu-central"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.LoadBalancer.Location != "" || cfg.LoadBalancer.NetworkZone != "eu-central" {
+					t.Errorf("expected only network zone eu-central, got location %q and zone %q", cfg.LoadBalancer.Location, cfg.LoadBalancer.NetworkZone)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := readConfig(strings.NewReader(tt.config))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		errors []string
+	}{
+		{
+			name:   "unknown field",
+			config: "version: v1\ntoken: file-token\nunknown: true\n",
+			errors: []string{"unable to parse cloud config"},
+		},
+		{
+			name:   "every invalid field is listed",
+			config: "version: v2\nendpoint: not-a-url\nnetwork:\n  id: 1\n  name: both\nloadBalancer:\n  nameTemplate: \"\"\n  type: \"\"\n",
+			errors: []string{
+				"version:",
+				"token:",
+				"endpoint:",
+				"network:",
+				"loadBalancer.nameTemplate:",
+				"loadBalancer.type:",
+			},
+		},
+		{
+			name:   "invalid env values",
+			config: "version: v1\ntoken: file-token\n",
+			env: map[string]string{
+				envNetworkID:          "my-network",
+				envLoadBalancerRetain: "maybe",
+				envFirewallEnabled:    "sometimes",
+			},
+			errors: []string{envNetworkID, envLoadBalancerRetain, envFirewallEnabled},
+		},
+		{
+			name:   "use private IP without network",
+			config: "version: v1\ntoken: file-token\nloadBalancer:\n  usePrivateIP: true\n",
+			errors: []string{"loadBalancer.usePrivateIP:"},
+		},
+		{
+			name:   "firewall without load balancers",
+			config: "version: v1\ntoken: file-token\nfeatures:\n  loadBalancers: false\n  firewall: true\n",
+			errors: []string{"features.firewall:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := readConfig(strings.NewReader(tt.config))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, expected := range tt.errors {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected error to contain %q, got: %v", expected, err)
+				}
+			}
+		})
+	}
+}
+
+// clearConfigEnv unsets all environment variables read by readConfig for the duration of the test.
+func clearConfigEnv(t *testing.T) {
+	for _, key := range []string{
+		envToken, envTokenFile, envEndpoint, envDebug, envClusterName, envNetwork, envNetworkID, envNetworkName,
+		envLoadBalancerType, envLoadBalancerLocation, envLoadBalancerZone, envLoadBalancerPrivate, envLoadBalancerRetain,
+		envLoadBalancersEnabled, envRoutesEnabled, envFirewallEnabled, envFirewallName,
+	} {
+		t.Setenv(key, "")
+	}
+}
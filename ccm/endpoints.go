@@ -0,0 +1,219 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// endpointsWatcher follows the EndpointSlices of Services that only target nodes with ready endpoints and updates the
+// targets of their Load Balancers when the endpoints move. The service controller of the cloud-provider library only
+// syncs Load Balancers when Services or nodes change.
+type endpointsWatcher struct {
+	endpointSlices discoverylisters.EndpointSliceLister
+	services       corelisters.ServiceLister
+	nodes          corelisters.NodeLister
+	synced         []cache.InformerSynced
+
+	queue workqueue.RateLimitingInterface
+}
+
+func newEndpointsWatcher(factory informers.SharedInformerFactory) (*endpointsWatcher, error) {
+	endpointSlices := factory.Discovery().V1().EndpointSlices()
+	services := factory.Core().V1().Services()
+	nodes := factory.Core().V1().Nodes()
+
+	w := &endpointsWatcher{
+		endpointSlices: endpointSlices.Lister(),
+		services:       services.Lister(),
+		nodes:          nodes.Lister(),
+		synced: []cache.InformerSynced{
+			endpointSlices.Informer().HasSynced,
+			services.Informer().HasSynced,
+			nodes.Informer().HasSynced,
+		},
+		queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	_, err := endpointSlices.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.enqueue,
+		UpdateFunc: func(_, obj interface{}) { w.enqueue(obj) },
+		DeleteFunc: w.enqueue,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch endpoint slices: %w", err)
+	}
+
+	return w, nil
+}
+
+func (w *endpointsWatcher) enqueue(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+
+	serviceName, ok := endpointSlice.Labels[discoveryv1.LabelServiceName]
+	if !ok {
+		return
+	}
+
+	w.queue.Add(endpointSlice.Namespace + "/" + serviceName)
+}
+
+// run processes the queue until stop is closed.
+func (w *endpointsWatcher) run(l LoadBalancer, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+		w.queue.ShutDown()
+	}()
+
+	if !cache.WaitForCacheSync(stop, w.synced...) {
+		return
+	}
+
+	for {
+		key, shutdown := w.queue.Get()
+		if shutdown {
+			return
+		}
+
+		if err := w.sync(ctx, l, key.(string)); err != nil {
+			klog.ErrorS(err, "unable to update targets from endpoints", "service", key)
+			w.queue.AddRateLimited(key)
+		} else {
+			w.queue.Forget(key)
+		}
+		w.queue.Done(key)
+	}
+}
+
+func (w *endpointsWatcher) sync(ctx context.Context, l LoadBalancer, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	service, err := w.services.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	enabled, err := usesTargetsWithEndpointsOnly(service)
+	if err != nil || !enabled || service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		// Invalid annotations are reported by the service controller
+		return nil
+	}
+
+	unlock := l.locks.lock(service.UID)
+	defer unlock()
+
+	lb, err := l.getLoadBalancer(ctx, l.clusterName, service)
+	if errors.Is(err, errLBOwnedByOther) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if lb == nil {
+		// Not created yet, the service controller adds the targets on create
+		return nil
+	}
+
+	allNodes, err := w.nodes.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	var nodes []*v1.Node
+	for _, node := range allNodes {
+		if isTargetableNode(node) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return l.updateLBTargets(ctx, l.clusterName, service, nodes, lb)
+}
+
+// nodesWithReadyEndpoints returns the names of the nodes that host ready endpoints of the Service.
+func (w *endpointsWatcher) nodesWithReadyEndpoints(service *v1.Service) (map[string]bool, error) {
+	endpointSlices, err := w.endpointSlices.EndpointSlices(service.Namespace).List(
+		labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: service.Name}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list endpoint slices: %w", err)
+	}
+
+	nodeNames := map[string]bool{}
+	for _, endpointSlice := range endpointSlices {
+		for _, endpoint := range endpointSlice.Endpoints {
+			// A nil condition is to be interpreted as ready
+			if endpoint.NodeName == nil || (endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready) {
+				continue
+			}
+			nodeNames[*endpoint.NodeName] = true
+		}
+	}
+
+	return nodeNames, nil
+}
+
+func usesTargetsWithEndpointsOnly(service *v1.Service) (bool, error) {
+	if service.Spec.ExternalTrafficPolicy != v1.ServiceExternalTrafficPolicyLocal {
+		return false, nil
+	}
+
+	enabled, _, err := getAnnotationBool(service, annotationTargetsWithEndpointsOnly)
+	return enabled, err
+}
+
+// filterNodesWithEndpoints returns only the nodes that host ready endpoints of the Service, if the Service uses
+// externalTrafficPolicy Local and annotationTargetsWithEndpointsOnly. Without the watcher all nodes are returned, the
+// health check then takes nodes without endpoints out of rotation.
+func (l LoadBalancer) filterNodesWithEndpoints(service *v1.Service, nodes []*v1.Node, targetType string) ([]*v1.Node, error) {
+	enabled, err := usesTargetsWithEndpointsOnly(service)
+	if err != nil || !enabled {
+		return nodes, err
+	}
+
+	if targetType == targetTypeLabelSelector {
+		return nil, fmt.Errorf("annotation %s: %w", annotationTargetsWithEndpointsOnly, errSharedClusterLabel)
+	}
+
+	if l.endpoints == nil {
+		return nodes, nil
+	}
+
+	nodeNames, err := l.endpoints.nodesWithReadyEndpoints(service)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]*v1.Node, 0, len(nodeNames))
+	for _, node := range nodes {
+		if nodeNames[node.Name] {
+			filtered = append(filtered, node)
+		}
+	}
+
+	return filtered, nil
+}
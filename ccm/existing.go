@@ -0,0 +1,246 @@
+package ccm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"net"
+	"slices"
+)
+
+// Existing Load Balancers are referenced through annotationLBExisting and are not owned by the CCM. The CCM records
+// which services and targets it added, so it never removes anything it did not add itself. The record is kept in
+// annotationExistingState on the Service, as the labels of the Load Balancer and its servers are usually managed by
+// other tools, e.g. Terraform, which reset them. An existing Load Balancer is claimed by the first Service that records
+// it, and other Services are rejected.
+
+func isExistingLB(service *v1.Service) bool {
+	_, ok := getAnnotation(service, annotationLBExisting)
+	return ok
+}
+
+// existingLBState is the record of the services and targets the CCM added to an existing Load Balancer.
+type existingLBState struct {
+	LoadBalancerID int64    `json:"loadBalancerID"`
+	Ports          []int    `json:"ports,omitempty"`
+	Servers        []int64  `json:"servers,omitempty"`
+	IPs            []string `json:"ips,omitempty"`
+}
+
+func parseExistingLBState(service *v1.Service) (existingLBState, error) {
+	var state existingLBState
+
+	value, ok := getAnnotation(service, annotationExistingState)
+	if !ok {
+		return state, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		return state, fmt.Errorf("annotation %s: %w", annotationExistingState, err)
+	}
+
+	// setMember expects sorted slices
+	slices.Sort(state.Ports)
+	slices.Sort(state.Servers)
+	slices.Sort(state.IPs)
+	return state, nil
+}
+
+func (s *existingLBState) hasPort(port int) bool {
+	return slices.Contains(s.Ports, port)
+}
+
+func (s *existingLBState) setPort(port int, managed bool) {
+	s.Ports = setMember(s.Ports, port, managed)
+}
+
+func (s *existingLBState) hasServer(serverID int64) bool {
+	return slices.Contains(s.Servers, serverID)
+}
+
+func (s *existingLBState) setServer(serverID int64, managed bool) {
+	s.Servers = setMember(s.Servers, serverID, managed)
+}
+
+func (s *existingLBState) hasIP(ip net.IP) bool {
+	return slices.Contains(s.IPs, ip.String())
+}
+
+func (s *existingLBState) setIP(ip net.IP, managed bool) {
+	s.IPs = setMember(s.IPs, ip.String(), managed)
+}
+
+// setMember adds or removes the value and keeps the slice sorted, so the annotation only changes with its content.
+func setMember[T int | int64 | string](values []T, value T, member bool) []T {
+	i, found := slices.BinarySearch(values, value)
+	switch {
+	case member && !found:
+		return slices.Insert(values, i, value)
+	case !member && found:
+		return slices.Delete(values, i, i+1)
+	default:
+		return values
+	}
+}
+
+// getExistingLBState returns the record of the existing Load Balancer, or nil if the Service does not use an existing
+// Load Balancer. The Service is read from the API, as the copy of the caller may not include the last update of the
+// record yet. A record of another Load Balancer, e.g. after the annotation was changed, is discarded.
+func (l LoadBalancer) getExistingLBState(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) (*existingLBState, error) {
+	if !isExistingLB(service) {
+		return nil, nil
+	}
+
+	current, err := l.getCurrentService(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := parseExistingLBState(current)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.LoadBalancerID != lb.ID {
+		state = existingLBState{LoadBalancerID: lb.ID}
+	}
+	return &state, nil
+}
+
+// getCurrentService returns the latest version of the Service, or the Service itself if it is already gone.
+func (l LoadBalancer) getCurrentService(ctx context.Context, service *v1.Service) (*v1.Service, error) {
+	if l.kubeClient == nil {
+		return nil, fmt.Errorf("kubernetes client is not initialized")
+	}
+
+	current, err := l.kubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && current.UID != service.UID) {
+		return service, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get service: %w", err)
+	}
+	return current, nil
+}
+
+// saveExistingLBState stores the record on the Service. A nil state removes the annotation.
+func (l LoadBalancer) saveExistingLBState(ctx context.Context, service *v1.Service, state *existingLBState) error {
+	if l.kubeClient == nil {
+		return fmt.Errorf("kubernetes client is not initialized")
+	}
+
+	var value any
+	if state != nil {
+		encoded, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("unable to encode annotation %s: %w", annotationExistingState, err)
+		}
+		value = string(encoded)
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{annotationExistingState: value},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to encode patch: %w", err)
+	}
+
+	_, err = l.kubeClient.CoreV1().Services(service.Namespace).Patch(ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) && state == nil {
+			// Nothing left to clean up
+			return nil
+		}
+		return fmt.Errorf("unable to update annotation %s: %w", annotationExistingState, err)
+	}
+	return nil
+}
+
+// checkExistingLBClaim returns errLBOwnedByOther if another Service already recorded the existing Load Balancer.
+func (l LoadBalancer) checkExistingLBClaim(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	if l.kubeClient == nil {
+		return fmt.Errorf("kubernetes client is not initialized")
+	}
+
+	services, err := l.kubeClient.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list services: %w", err)
+	}
+
+	for i := range services.Items {
+		other := &services.Items[i]
+		if other.UID == service.UID || !isExistingLB(other) {
+			continue
+		}
+
+		state, err := parseExistingLBState(other)
+		if err != nil {
+			// Records of other Services are not ours to fix
+			continue
+		}
+		if state.LoadBalancerID == lb.ID {
+			return fmt.Errorf("%w: existing loadbalancer %s is already used by service %s", errLBOwnedByOther, lb.Name, klog.KObj(other))
+		}
+	}
+
+	return nil
+}
+
+// claimExistingLB records the existing Load Balancer on the Service, so other Services are rejected.
+func (l LoadBalancer) claimExistingLB(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	state, err := l.getExistingLBState(ctx, service, lb)
+	if err != nil {
+		return err
+	}
+
+	return l.saveExistingLBState(ctx, service, state)
+}
+
+// releaseExistingLB removes the services and targets the CCM added to the existing Load Balancer.
+func (l LoadBalancer) releaseExistingLB(ctx context.Context, clusterName string, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	state, err := l.getExistingLBState(ctx, service, lb)
+	if err != nil {
+		return err
+	}
+
+	for _, svc := range lb.Services {
+		if !state.hasPort(svc.ListenPort) {
+			continue
+		}
+
+		if _, _, err = l.client.LoadBalancer.DeleteService(ctx, lb, svc.ListenPort); err != nil {
+			return fmt.Errorf("unable to delete loadbalancer service %d: %w", svc.ListenPort, err)
+		}
+		state.setPort(svc.ListenPort, false)
+		if err = l.saveExistingLBState(ctx, service, state); err != nil {
+			return err
+		}
+	}
+
+	if err = l.updateLBServerTargets(ctx, service, nil, lb, false, state); err != nil {
+		return err
+	}
+
+	if err = l.removeManagedLabelSelectorTargets(ctx, clusterName, lb); err != nil {
+		return err
+	}
+
+	if err = l.updateLBIPTargets(ctx, service, nil, lb, state); err != nil {
+		return err
+	}
+
+	if err = l.saveExistingLBState(ctx, service, nil); err != nil {
+		return err
+	}
+
+	klog.InfoS("released existing loadbalancer", "service", klog.KObj(service), "loadBalancer", lb.Name)
+	return nil
+}
@@ -0,0 +1,80 @@
+package ccm
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestExistingLBState(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		update     func(state *existingLBState)
+		expected   existingLBState
+		wantErr    bool
+	}{
+		{
+			name:     "not set",
+			expected: existingLBState{},
+		},
+		{
+			name:       "unsorted record",
+			annotation: `{"loadBalancerID":1,"ports":[443,80],"servers":[3,2],"ips":["192.0.2.2","192.0.2.1"]}`,
+			expected: existingLBState{
+				LoadBalancerID: 1,
+				Ports:          []int{80, 443},
+				Servers:        []int64{2, 3},
+				IPs:            []string{"192.0.2.1", "192.0.2.2"},
+			},
+		},
+		{
+			name:       "add and remove",
+			annotation: `{"loadBalancerID":1,"ports":[80],"servers":[2]}`,
+			update: func(state *existingLBState) {
+				state.setPort(443, true)
+				state.setPort(80, false)
+				state.setServer(2, true)
+				state.setIP(net.ParseIP("2001:db8::1"), true)
+			},
+			expected: existingLBState{
+				LoadBalancerID: 1,
+				Ports:          []int{443},
+				Servers:        []int64{2},
+				IPs:            []string{"2001:db8::1"},
+			},
+		},
+		{
+			name:       "invalid",
+			annotation: `80,443`,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.annotation != "" {
+				annotations[annotationExistingState] = tt.annotation
+			}
+
+			state, err := parseExistingLBState(newAnnotatedService(annotations))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.update != nil {
+				tt.update(&state)
+			}
+			if !reflect.DeepEqual(state, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, state)
+			}
+		})
+	}
+}
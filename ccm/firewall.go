@@ -0,0 +1,431 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// firewallQueueKey is the only key in the queue, every sync reconciles the whole Firewall.
+	firewallQueueKey = "firewall"
+
+	// firewallResyncPeriod is the time after which the Firewall is synced again to correct manual changes.
+	firewallResyncPeriod = 5 * time.Minute
+
+	firewallRuleDescription = "NodePorts of Services of type LoadBalancer"
+)
+
+var errFirewallOwnedByOther = errors.New("firewall is not owned by the CCM of the cluster")
+
+// firewallController keeps a Firewall owned by the CCM applied to the servers of all nodes. It allows the NodePorts of
+// all Services of type LoadBalancer from the private network and the IPs of their Load Balancers. hcloud combines the
+// rules of all Firewalls applied to a server, so all other traffic has to be allowed through other Firewalls. While
+// there are no rules the Firewall is not applied to any server, as it would drop all public inbound traffic.
+type firewallController struct {
+	client    *hcloud.Client
+	name      string
+	networkID int64
+
+	// clusterName is the cluster name from the cloud config, the Firewall is labeled with it to mark it as owned by the
+	// CCM.
+	clusterName string
+
+	services corelisters.ServiceLister
+	nodes    corelisters.NodeLister
+	synced   []cache.InformerSynced
+
+	queue workqueue.RateLimitingInterface
+}
+
+func newFirewallController(client *hcloud.Client, name string, networkID int64, clusterName string, factory informers.SharedInformerFactory) (*firewallController, error) {
+	services := factory.Core().V1().Services()
+	nodes := factory.Core().V1().Nodes()
+
+	c := &firewallController{
+		client:      client,
+		name:        name,
+		networkID:   networkID,
+		clusterName: clusterName,
+		services:    services.Lister(),
+		nodes:       nodes.Lister(),
+		synced: []cache.InformerSynced{
+			services.Informer().HasSynced,
+			nodes.Informer().HasSynced,
+		},
+		queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	enqueue := func(interface{}) { c.queue.Add(firewallQueueKey) }
+
+	_, err := services.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch services: %w", err)
+	}
+
+	_, err = nodes.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(oldObj, obj interface{}) {
+			// Nodes are updated all the time, only the ProviderID matters for the Firewall
+			if oldObj.(*v1.Node).Spec.ProviderID != obj.(*v1.Node).Spec.ProviderID {
+				enqueue(obj)
+			}
+		},
+		DeleteFunc: enqueue,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch nodes: %w", err)
+	}
+
+	return c, nil
+}
+
+// run processes the queue until stop is closed.
+func (c *firewallController) run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+		c.queue.ShutDown()
+	}()
+
+	if !cache.WaitForCacheSync(stop, c.synced...) {
+		return
+	}
+
+	for {
+		key, shutdown := c.queue.Get()
+		if shutdown {
+			return
+		}
+
+		if err := c.sync(ctx); err != nil {
+			klog.ErrorS(err, "unable to sync firewall", "firewall", c.name)
+			c.queue.AddRateLimited(key)
+		} else {
+			c.queue.Forget(key)
+			c.queue.AddAfter(key, firewallResyncPeriod)
+		}
+		c.queue.Done(key)
+	}
+}
+
+func (c *firewallController) sync(ctx context.Context) error {
+	var networkRange *net.IPNet
+	if c.networkID != 0 {
+		network, _, err := c.client.Network.GetByID(ctx, c.networkID)
+		if err != nil {
+			return fmt.Errorf("unable to get network: %w", err)
+		}
+		if network == nil {
+			return fmt.Errorf("network not found: ID=%d", c.networkID)
+		}
+		networkRange = network.IPRange
+	}
+
+	services, err := c.services.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	lbIPs, err := c.getLBIPs(ctx)
+	if err != nil {
+		return err
+	}
+	rules := getFirewallRules(services, networkRange, lbIPs)
+
+	if len(rules) == 0 {
+		// A Firewall without rules drops all public inbound traffic, so it is removed from the servers instead
+		firewall, err := c.getFirewall(ctx, c.clusterName)
+		if err != nil || firewall == nil {
+			return err
+		}
+		return c.applyToNodes(ctx, firewall, false)
+	}
+
+	firewall, err := c.ensureFirewall(ctx, c.clusterName, rules)
+	if err != nil {
+		return err
+	}
+
+	if !firewallRulesEqual(firewall.Rules, rules) {
+		klog.InfoS("updating firewall rules", "firewall", firewall.Name, "rules", len(rules))
+
+		actions, _, err := c.client.Firewall.SetRules(ctx, firewall, hcloud.FirewallSetRulesOpts{Rules: rules})
+		if err != nil {
+			return fmt.Errorf("unable to set firewall rules: %w", err)
+		}
+
+		if err = waitForAction(ctx, c.client, actions...); err != nil {
+			return fmt.Errorf("unable to set firewall rules: %w", err)
+		}
+	}
+
+	return c.applyToNodes(ctx, firewall, true)
+}
+
+// getLBIPs returns the public IPs of all Load Balancers used by a Service by the UID of the Service. Services with the
+// use-hostname annotation do not publish the IPs in their status.
+func (c *firewallController) getLBIPs(ctx context.Context) (map[types.UID][]net.IP, error) {
+	lbs, err := c.client.LoadBalancer.AllWithOpts(ctx, hcloud.LoadBalancerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: labelServiceUID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list loadbalancers: %w", err)
+	}
+
+	lbIPs := make(map[types.UID][]net.IP, len(lbs))
+	for _, lb := range lbs {
+		uid := types.UID(lb.Labels[labelServiceUID])
+		if lb.PublicNet.IPv4.IP != nil {
+			lbIPs[uid] = append(lbIPs[uid], lb.PublicNet.IPv4.IP)
+		}
+		if lb.PublicNet.IPv6.IP != nil {
+			lbIPs[uid] = append(lbIPs[uid], lb.PublicNet.IPv6.IP)
+		}
+	}
+
+	return lbIPs, nil
+}
+
+// getFirewall returns the Firewall of the CCM, or nil if it does not exist. A Firewall with the name that was not
+// created by the CCM of the cluster is never used, as the CCM would replace its rules.
+func (c *firewallController) getFirewall(ctx context.Context, clusterName string) (*hcloud.Firewall, error) {
+	firewall, _, err := c.client.Firewall.GetByName(ctx, c.name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get firewall: %w", err)
+	}
+	if firewall != nil && firewall.Labels[labelCluster] != clusterName {
+		return nil, fmt.Errorf("%w: %s", errFirewallOwnedByOther, c.name)
+	}
+	return firewall, nil
+}
+
+// ensureFirewall returns the Firewall of the CCM and creates it with the rules if it does not exist yet.
+func (c *firewallController) ensureFirewall(ctx context.Context, clusterName string, rules []hcloud.FirewallRule) (*hcloud.Firewall, error) {
+	firewall, err := c.getFirewall(ctx, clusterName)
+	if err != nil || firewall != nil {
+		return firewall, err
+	}
+
+	klog.InfoS("creating firewall", "firewall", c.name)
+
+	result, _, err := c.client.Firewall.Create(ctx, hcloud.FirewallCreateOpts{
+		Name:   c.name,
+		Labels: map[string]string{labelCluster: clusterName},
+		Rules:  rules,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create firewall: %w", err)
+	}
+
+	if err = waitForAction(ctx, c.client, result.Actions...); err != nil {
+		return nil, fmt.Errorf("unable to create firewall: %w", err)
+	}
+
+	return result.Firewall, nil
+}
+
+// applyToNodes applies the Firewall to the servers of all nodes and removes it from all other servers. If apply is
+// false, it is removed from all servers. Label selector resources are not touched.
+func (c *firewallController) applyToNodes(ctx context.Context, firewall *hcloud.Firewall, apply bool) error {
+	wanted := map[int64]bool{}
+	if apply {
+		nodes, err := c.nodes.List(labels.Everything())
+		if err != nil {
+			return err
+		}
+
+		for _, node := range nodes {
+			// Nodes that are not hcloud servers can not be protected by the Firewall
+			if serverID, err := getProviderID(node); err == nil {
+				wanted[serverID] = true
+			}
+		}
+	}
+
+	applied := map[int64]bool{}
+	var remove []hcloud.FirewallResource
+	for _, resource := range firewall.AppliedTo {
+		if resource.Type != hcloud.FirewallResourceTypeServer {
+			continue
+		}
+
+		applied[resource.Server.ID] = true
+		if !wanted[resource.Server.ID] {
+			remove = append(remove, resource)
+		}
+	}
+
+	var add []hcloud.FirewallResource
+	for serverID := range wanted {
+		if !applied[serverID] {
+			add = append(add, hcloud.FirewallResource{
+				Type:   hcloud.FirewallResourceTypeServer,
+				Server: &hcloud.FirewallResourceServer{ID: serverID},
+			})
+		}
+	}
+
+	if len(add) > 0 {
+		klog.InfoS("applying firewall to servers", "firewall", firewall.Name, "servers", len(add))
+
+		actions, _, err := c.client.Firewall.ApplyResources(ctx, firewall, add)
+		if err != nil {
+			return fmt.Errorf("unable to apply firewall: %w", err)
+		}
+
+		if err = waitForAction(ctx, c.client, actions...); err != nil {
+			return fmt.Errorf("unable to apply firewall: %w", err)
+		}
+	}
+
+	if len(remove) > 0 {
+		klog.InfoS("removing firewall from servers", "firewall", firewall.Name, "servers", len(remove))
+
+		actions, _, err := c.client.Firewall.RemoveResources(ctx, firewall, remove)
+		if err != nil {
+			return fmt.Errorf("unable to remove firewall: %w", err)
+		}
+
+		if err = waitForAction(ctx, c.client, actions...); err != nil {
+			return fmt.Errorf("unable to remove firewall: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getFirewallRules returns a single rule for the TCP NodePorts and health check NodePorts of the Services of type
+// LoadBalancer. hcloud limits the number of rules per Firewall, so the rule covers the range from the lowest to the
+// highest of these ports instead of one rule per port. It allows traffic from the private network, from the IPs in the
+// status of the Services and from the IPs of their Load Balancers in lbIPs. Services that have no Load Balancer IP yet
+// and no network to allow are skipped.
+func getFirewallRules(services []*v1.Service, networkRange *net.IPNet, lbIPs map[types.UID][]net.IP) []hcloud.FirewallRule {
+	var ports []int32
+	var sourceIPs []net.IPNet
+	seen := map[string]bool{}
+
+	addSourceIP := func(ipNet net.IPNet) {
+		if !seen[ipNet.String()] {
+			seen[ipNet.String()] = true
+			sourceIPs = append(sourceIPs, ipNet)
+		}
+	}
+
+	for _, service := range services {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+
+		ips := slices.Clone(lbIPs[service.UID])
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ip := net.ParseIP(ingress.IP); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) == 0 && networkRange == nil {
+			continue
+		}
+
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				addSourceIP(net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)})
+			} else {
+				addSourceIP(net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+		}
+
+		for _, port := range service.Spec.Ports {
+			// Load Balancers only forward TCP
+			if port.Protocol == v1.ProtocolTCP && port.NodePort != 0 {
+				ports = append(ports, port.NodePort)
+			}
+		}
+		if service.Spec.HealthCheckNodePort != 0 {
+			ports = append(ports, service.Spec.HealthCheckNodePort)
+		}
+	}
+
+	if len(ports) == 0 {
+		return nil
+	}
+	if networkRange != nil {
+		addSourceIP(*networkRange)
+	}
+
+	minPort, maxPort := slices.Min(ports), slices.Max(ports)
+	port := strconv.Itoa(int(minPort))
+	if maxPort != minPort {
+		port += "-" + strconv.Itoa(int(maxPort))
+	}
+
+	return []hcloud.FirewallRule{{
+		Direction:   hcloud.FirewallRuleDirectionIn,
+		Protocol:    hcloud.FirewallRuleProtocolTCP,
+		Port:        hcloud.Ptr(port),
+		SourceIPs:   sourceIPs,
+		Description: hcloud.Ptr(firewallRuleDescription),
+	}}
+}
+
+// firewallRulesEqual compares the rules independent of their order.
+func firewallRulesEqual(a, b []hcloud.FirewallRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	keysA := make([]string, 0, len(a))
+	for _, rule := range a {
+		keysA = append(keysA, firewallRuleKey(rule))
+	}
+	keysB := make([]string, 0, len(b))
+	for _, rule := range b {
+		keysB = append(keysB, firewallRuleKey(rule))
+	}
+
+	slices.Sort(keysA)
+	slices.Sort(keysB)
+	return slices.Equal(keysA, keysB)
+}
+
+func firewallRuleKey(rule hcloud.FirewallRule) string {
+	var port, description string
+	if rule.Port != nil {
+		port = *rule.Port
+	}
+	if rule.Description != nil {
+		description = *rule.Description
+	}
+
+	sourceIPs := make([]string, 0, len(rule.SourceIPs))
+	for _, sourceIP := range rule.SourceIPs {
+		sourceIPs = append(sourceIPs, sourceIP.String())
+	}
+	slices.Sort(sourceIPs)
+
+	return strings.Join([]string{
+		string(rule.Direction),
+		string(rule.Protocol),
+		port,
+		description,
+		strings.Join(sourceIPs, ","),
+	}, "|")
+}
@@ -0,0 +1,142 @@
+package ccm
+
+import (
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	"testing"
+)
+
+func TestGetFirewallRules(t *testing.T) {
+	_, networkRange, _ := net.ParseCIDR("10.0.0.0/16")
+
+	newService := func(uid types.UID, serviceType v1.ServiceType, ingressIPs ...string) *v1.Service {
+		service := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: string(uid), UID: uid},
+			Spec: v1.ServiceSpec{
+				Type: serviceType,
+				Ports: []v1.ServicePort{
+					{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080},
+					{Protocol: v1.ProtocolUDP, Port: 53, NodePort: 30053},
+				},
+			},
+		}
+		for _, ip := range ingressIPs {
+			service.Status.LoadBalancer.Ingress = append(service.Status.LoadBalancer.Ingress, v1.LoadBalancerIngress{IP: ip})
+		}
+		return service
+	}
+
+	withHealthCheckNodePort := newService("local", v1.ServiceTypeLoadBalancer, "192.0.2.2")
+	withHealthCheckNodePort.Spec.HealthCheckNodePort = 32000
+
+	withOtherNodePort := newService("api", v1.ServiceTypeLoadBalancer, "192.0.2.4", "192.0.2.1")
+	withOtherNodePort.Spec.Ports[0].NodePort = 31080
+
+	tests := []struct {
+		name         string
+		services     []*v1.Service
+		networkRange *net.IPNet
+		lbIPs        map[types.UID][]net.IP
+		expected     []hcloud.FirewallRule
+	}{
+		{
+			name:     "no services",
+			expected: nil,
+		},
+		{
+			name: "only services of type LoadBalancer",
+			services: []*v1.Service{
+				newService("node-port", v1.ServiceTypeNodePort, "192.0.2.1"),
+				newService("web", v1.ServiceTypeLoadBalancer, "192.0.2.1", "2001:db8::1", "lb.example.com"),
+			},
+			expected: []hcloud.FirewallRule{
+				firewallRule("30080", "192.0.2.1/32", "2001:db8::1/128"),
+			},
+		},
+		{
+			name:     "no sources yet",
+			services: []*v1.Service{newService("web", v1.ServiceTypeLoadBalancer)},
+			expected: nil,
+		},
+		{
+			name:         "network range",
+			services:     []*v1.Service{newService("web", v1.ServiceTypeLoadBalancer)},
+			networkRange: networkRange,
+			expected: []hcloud.FirewallRule{
+				firewallRule("30080", "10.0.0.0/16"),
+			},
+		},
+		{
+			name:     "health check node port",
+			services: []*v1.Service{withHealthCheckNodePort},
+			expected: []hcloud.FirewallRule{
+				firewallRule("30080-32000", "192.0.2.2/32"),
+			},
+		},
+		{
+			name:     "load balancer IPs of services without IPs in the status",
+			services: []*v1.Service{newService("hostname", v1.ServiceTypeLoadBalancer)},
+			lbIPs: map[types.UID][]net.IP{
+				"hostname": {net.ParseIP("192.0.2.3"), net.ParseIP("2001:db8::3")},
+			},
+			expected: []hcloud.FirewallRule{
+				firewallRule("30080", "192.0.2.3/32", "2001:db8::3/128"),
+			},
+		},
+		{
+			name:     "load balancer IPs are not repeated",
+			services: []*v1.Service{newService("web", v1.ServiceTypeLoadBalancer, "192.0.2.1")},
+			lbIPs: map[types.UID][]net.IP{
+				"web": {net.ParseIP("192.0.2.1")},
+			},
+			expected: []hcloud.FirewallRule{
+				firewallRule("30080", "192.0.2.1/32"),
+			},
+		},
+		{
+			name: "services share a single rule",
+			services: []*v1.Service{
+				newService("web", v1.ServiceTypeLoadBalancer, "192.0.2.1"),
+				withOtherNodePort,
+			},
+			networkRange: networkRange,
+			expected: []hcloud.FirewallRule{
+				firewallRule("30080-31080", "192.0.2.1/32", "192.0.2.4/32", "10.0.0.0/16"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := getFirewallRules(tt.services, tt.networkRange, tt.lbIPs)
+			if !firewallRulesEqual(rules, tt.expected) {
+				t.Errorf("expected %v, got %v", ruleKeys(tt.expected), ruleKeys(rules))
+			}
+		})
+	}
+}
+
+func firewallRule(port string, sourceIPs ...string) hcloud.FirewallRule {
+	rule := hcloud.FirewallRule{
+		Direction:   hcloud.FirewallRuleDirectionIn,
+		Protocol:    hcloud.FirewallRuleProtocolTCP,
+		Port:        hcloud.Ptr(port),
+		Description: hcloud.Ptr(firewallRuleDescription),
+	}
+	for _, sourceIP := range sourceIPs {
+		_, ipNet, _ := net.ParseCIDR(sourceIP)
+		rule.SourceIPs = append(rule.SourceIPs, *ipNet)
+	}
+	return rule
+}
+
+func ruleKeys(rules []hcloud.FirewallRule) []string {
+	keys := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		keys = append(keys, firewallRuleKey(rule))
+	}
+	return keys
+}
@@ -0,0 +1,165 @@
+package ccm
+
+import (
+	"fmt"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	v1 "k8s.io/api/core/v1"
+	"slices"
+	"time"
+)
+
+// Defaults of the Hetzner Cloud API, set explicitly so drift from them can be detected.
+const (
+	defaultHealthCheckInterval = 15 * time.Second
+	defaultHealthCheckTimeout  = 10 * time.Second
+	defaultHealthCheckRetries  = 3
+)
+
+var defaultHealthCheckStatusCodes = []string{"2??", "3??"}
+
+// getHealthCheck returns the desired health check for the port of the Service.
+func getHealthCheck(service *v1.Service, port v1.ServicePort) (hcloud.LoadBalancerServiceHealthCheck, error) {
+	healthCheck := hcloud.LoadBalancerServiceHealthCheck{
+		Protocol: hcloud.LoadBalancerServiceProtocolTCP,
+		Port:     int(port.NodePort),
+		Interval: defaultHealthCheckInterval,
+		Timeout:  defaultHealthCheckTimeout,
+		Retries:  defaultHealthCheckRetries,
+	}
+	httpPath := "/"
+
+	if service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal && service.Spec.HealthCheckNodePort != 0 {
+		// kube-proxy answers on the healthCheckNodePort with 200 only if the node has a local endpoint
+		healthCheck.Protocol = hcloud.LoadBalancerServiceProtocolHTTP
+		healthCheck.Port = int(service.Spec.HealthCheckNodePort)
+		httpPath = "/healthz"
+	}
+
+	if value, ok := getAnnotation(service, annotationHealthCheckProtocol); ok {
+		switch protocol := hcloud.LoadBalancerServiceProtocol(value); protocol {
+		case hcloud.LoadBalancerServiceProtocolTCP, hcloud.LoadBalancerServiceProtocolHTTP:
+			healthCheck.Protocol = protocol
+		default:
+			return healthCheck, fmt.Errorf("annotation %s: unsupported protocol %q", annotationHealthCheckProtocol, value)
+		}
+	}
+
+	if value, ok, err := getAnnotationInt(service, annotationHealthCheckPort); err != nil {
+		return healthCheck, err
+	} else if ok {
+		healthCheck.Port = value
+	}
+
+	if value, ok, err := getAnnotationDuration(service, annotationHealthCheckInterval); err != nil {
+		return healthCheck, err
+	} else if ok {
+		healthCheck.Interval = value
+	}
+
+	if value, ok, err := getAnnotationDuration(service, annotationHealthCheckTimeout); err != nil {
+		return healthCheck, err
+	} else if ok {
+		healthCheck.Timeout = value
+	}
+
+	if value, ok, err := getAnnotationInt(service, annotationHealthCheckRetries); err != nil {
+		return healthCheck, err
+	} else if ok {
+		healthCheck.Retries = value
+	}
+
+	if healthCheck.Protocol == hcloud.LoadBalancerServiceProtocolHTTP {
+		healthCheck.HTTP = &hcloud.LoadBalancerServiceHealthCheckHTTP{
+			Path:        httpPath,
+			StatusCodes: defaultHealthCheckStatusCodes,
+		}
+
+		if value, ok := getAnnotation(service, annotationHealthCheckHTTPPath); ok {
+			healthCheck.HTTP.Path = value
+		}
+
+		if value, ok := getAnnotationStringSlice(service, annotationHealthCheckHTTPStatusCodes); ok {
+			healthCheck.HTTP.StatusCodes = value
+		}
+	}
+
+	return healthCheck, nil
+}
+
+// diffHealthCheck returns the names of the fields managed by the CCM that differ between the actual and the desired
+// health check.
+func diffHealthCheck(actual, desired hcloud.LoadBalancerServiceHealthCheck) []string {
+	var fields []string
+
+	if actual.Protocol != desired.Protocol {
+		fields = append(fields, "healthCheck.protocol")
+	}
+	if actual.Port != desired.Port {
+		fields = append(fields, "healthCheck.port")
+	}
+	if actual.Interval != desired.Interval {
+		fields = append(fields, "healthCheck.interval")
+	}
+	if actual.Timeout != desired.Timeout {
+		fields = append(fields, "healthCheck.timeout")
+	}
+	if actual.Retries != desired.Retries {
+		fields = append(fields, "healthCheck.retries")
+	}
+
+	if desired.Protocol != hcloud.LoadBalancerServiceProtocolHTTP || desired.HTTP == nil {
+		// HTTP settings are only relevant for HTTP health checks
+		return fields
+	}
+
+	actualHTTP := actual.HTTP
+	if actualHTTP == nil {
+		actualHTTP = &hcloud.LoadBalancerServiceHealthCheckHTTP{}
+	}
+	if actualHTTP.Path != desired.HTTP.Path {
+		fields = append(fields, "healthCheck.http.path")
+	}
+	if !slices.Equal(actualHTTP.StatusCodes, desired.HTTP.StatusCodes) {
+		fields = append(fields, "healthCheck.http.statusCodes")
+	}
+
+	return fields
+}
+
+func healthCheckAddOpts(healthCheck hcloud.LoadBalancerServiceHealthCheck) *hcloud.LoadBalancerAddServiceOptsHealthCheck {
+	opts := &hcloud.LoadBalancerAddServiceOptsHealthCheck{
+		Protocol: healthCheck.Protocol,
+		Port:     hcloud.Ptr(healthCheck.Port),
+		Interval: hcloud.Ptr(healthCheck.Interval),
+		Timeout:  hcloud.Ptr(healthCheck.Timeout),
+		Retries:  hcloud.Ptr(healthCheck.Retries),
+	}
+
+	if healthCheck.HTTP != nil {
+		opts.HTTP = &hcloud.LoadBalancerAddServiceOptsHealthCheckHTTP{
+			Path:        hcloud.Ptr(healthCheck.HTTP.Path),
+			StatusCodes: healthCheck.HTTP.StatusCodes,
+		}
+	}
+
+	return opts
+}
+
+func healthCheckUpdateOpts(healthCheck hcloud.LoadBalancerServiceHealthCheck) *hcloud.LoadBalancerUpdateServiceOptsHealthCheck {
+	opts := &hcloud.LoadBalancerUpdateServiceOptsHealthCheck{
+		Protocol: healthCheck.Protocol,
+		Port:     hcloud.Ptr(healthCheck.Port),
+		Interval: hcloud.Ptr(healthCheck.Interval),
+		Timeout:  hcloud.Ptr(healthCheck.Timeout),
+		Retries:  hcloud.Ptr(healthCheck.Retries),
+	}
+
+	if healthCheck.HTTP != nil {
+		opts.HTTP = &hcloud.LoadBalancerUpdateServiceOptsHealthCheckHTTP{
+			Path:        hcloud.Ptr(healthCheck.HTTP.Path),
+			StatusCodes: healthCheck.HTTP.StatusCodes,
+		}
+	}
+
+	return opts
+}
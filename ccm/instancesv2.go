@@ -122,6 +122,11 @@ func getNodeAddresses(server *hcloud.Server, networkID int64) []v1.NodeAddress {
 		})
 	}
 
+	if networkID == 0 {
+		// Public-only mode, the node has no internal address
+		return addresses
+	}
+
 	for _, privNet := range server.PrivateNet {
 		if privNet.Network.ID != networkID {
 			continue
@@ -0,0 +1,25 @@
+package ccm
+
+// Labels set on resources in Hetzner Cloud that are managed by the CCM.
+const (
+	labelPrefix = "ccm-from-scratch/"
+
+	// labelServiceUID is the UID of the Kubernetes Service the resource was created for.
+	labelServiceUID = labelPrefix + "service-uid"
+
+	// labelCluster is the name of the cluster the resource was created for. On servers it is the name of the cluster
+	// the server is a node of, which is used by label selector targets. On the Firewall it marks it as owned by the CCM.
+	labelCluster = labelPrefix + "cluster"
+
+	// labelRetained marks a Load Balancer that the CCM kept after its Service was deleted. Together with labelCluster it
+	// limits adopting Load Balancers by their IPs to the ones this cluster retained.
+	labelRetained = labelPrefix + "retained"
+
+	// labelProtected marks a Load Balancer whose delete protection was enabled by the CCM, so it is disabled again when
+	// the annotation is removed.
+	labelProtected = labelPrefix + "protected"
+
+	// labelNetwork is the ID of the network the CCM attached the Load Balancer to. It is used to detach the Load
+	// Balancer when the configured network changes.
+	labelNetwork = labelPrefix + "network"
+)
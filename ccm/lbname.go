@@ -0,0 +1,71 @@
+package ccm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultLBNameTemplate = "{{cluster}}-{{namespace}}-{{name}}"
+
+	// maxLBNameLength is the maximum length of Load Balancer names accepted by the API.
+	maxLBNameLength  = 63
+	lbNameHashLength = 8
+)
+
+var (
+	lbNamePlaceholder  = regexp.MustCompile(`{{\s*(\w*)\s*}}`)
+	lbNameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+)
+
+// lbNamePlaceholders are the values that can be used in the name template.
+var lbNamePlaceholders = map[string]func(clusterName string, service *v1.Service) string{
+	"cluster":   func(clusterName string, _ *v1.Service) string { return clusterName },
+	"namespace": func(_ string, service *v1.Service) string { return service.Namespace },
+	"name":      func(_ string, service *v1.Service) string { return service.Name },
+	"uid":       func(_ string, service *v1.Service) string { return string(service.UID) },
+}
+
+// validateLBNameTemplate returns an error if the template uses unknown placeholders.
+func validateLBNameTemplate(template string) error {
+	for _, match := range lbNamePlaceholder.FindAllStringSubmatch(template, -1) {
+		if _, ok := lbNamePlaceholders[match[1]]; !ok {
+			return fmt.Errorf("unknown placeholder %q", match[0])
+		}
+	}
+
+	return nil
+}
+
+// getLBName returns the name of the Load Balancer for the Service. It is either set through annotationLBName or built
+// from the name template in the cloud config.
+func (l LoadBalancer) getLBName(clusterName string, service *v1.Service) string {
+	if name, ok := getAnnotation(service, annotationLBName); ok {
+		return sanitizeLBName(name)
+	}
+
+	name := lbNamePlaceholder.ReplaceAllStringFunc(l.config.NameTemplate, func(placeholder string) string {
+		key := lbNamePlaceholder.FindStringSubmatch(placeholder)[1]
+		return lbNamePlaceholders[key](clusterName, service)
+	})
+
+	return sanitizeLBName(name)
+}
+
+// sanitizeLBName replaces characters the API does not accept and deterministically shortens names that are too long,
+// by replacing the end of the name with a hash of the full name.
+func sanitizeLBName(name string) string {
+	name = strings.Trim(lbNameInvalidChars.ReplaceAllString(name, "-"), "-.")
+
+	if len(name) <= maxLBNameLength {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(name))
+	prefix := strings.TrimRight(name[:maxLBNameLength-lbNameHashLength-1], "-.")
+	return fmt.Sprintf("%s-%s", prefix, hex.EncodeToString(hash[:])[:lbNameHashLength])
+}
@@ -0,0 +1,40 @@
+package ccm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeLBName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	longOther := strings.Repeat("a", 69) + "b"
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "valid", input: "cluster-default-web", expected: "cluster-default-web"},
+		{name: "invalid characters", input: "cluster_default/web", expected: "cluster-default-web"},
+		{name: "leading and trailing separators", input: "-.cluster.web_", expected: "cluster.web"},
+		{name: "max length", input: strings.Repeat("a", maxLBNameLength), expected: strings.Repeat("a", maxLBNameLength)},
+		{name: "too long", input: long, expected: strings.Repeat("a", 54) + "-" + "6bd5e503"},
+		{name: "too long with separator before hash", input: strings.Repeat("a", 53) + "-" + strings.Repeat("b", 16), expected: strings.Repeat("a", 53) + "-" + "76da27ac"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := sanitizeLBName(tt.input)
+			if actual != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, actual)
+			}
+			if len(actual) > maxLBNameLength {
+				t.Errorf("name is longer than %d characters: %q", maxLBNameLength, actual)
+			}
+		})
+	}
+
+	if sanitizeLBName(long) == sanitizeLBName(longOther) {
+		t.Errorf("names that only differ after the truncation must get different hashes")
+	}
+}
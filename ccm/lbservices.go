@@ -0,0 +1,382 @@
+package ccm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sticky session defaults of the API, like the health check defaults.
+const (
+	defaultHTTPCookieName     = "HCLBSTICKY"
+	defaultHTTPCookieLifetime = 300 * time.Second
+)
+
+// lbServiceSettings are the settings of the Load Balancer services that are shared by all ports of a Service.
+type lbServiceSettings struct {
+	protocols       map[int]hcloud.LoadBalancerServiceProtocol
+	defaultProtocol hcloud.LoadBalancerServiceProtocol
+
+	proxyProtocolPorts map[int]bool
+	proxyProtocolAll   bool
+
+	http hcloud.LoadBalancerServiceHTTP
+}
+
+// getLBServiceSettings parses the annotations of the Service and resolves the certificates for HTTPS services.
+func (l LoadBalancer) getLBServiceSettings(ctx context.Context, service *v1.Service) (lbServiceSettings, error) {
+	settings := lbServiceSettings{
+		defaultProtocol: hcloud.LoadBalancerServiceProtocolTCP,
+		protocols:       map[int]hcloud.LoadBalancerServiceProtocol{},
+
+		proxyProtocolPorts: map[int]bool{},
+		http: hcloud.LoadBalancerServiceHTTP{
+			CookieName:     defaultHTTPCookieName,
+			CookieLifetime: defaultHTTPCookieLifetime,
+		},
+	}
+
+	if err := settings.parseProtocols(service); err != nil {
+		return settings, err
+	}
+
+	if err := settings.parseProxyProtocol(service); err != nil {
+		return settings, err
+	}
+
+	if value, ok, err := getAnnotationBool(service, annotationHTTPStickySessions); err != nil {
+		return settings, err
+	} else if ok {
+		settings.http.StickySessions = value
+	}
+
+	if value, ok := getAnnotation(service, annotationHTTPCookieName); ok {
+		settings.http.CookieName = value
+	}
+
+	if value, ok, err := getAnnotationDuration(service, annotationHTTPCookieLifetime); err != nil {
+		return settings, err
+	} else if ok {
+		settings.http.CookieLifetime = value
+	}
+
+	if value, ok, err := getAnnotationBool(service, annotationHTTPRedirectHTTP); err != nil {
+		return settings, err
+	} else if ok {
+		settings.http.RedirectHTTP = value
+	}
+
+	if settings.usesProtocol(service, hcloud.LoadBalancerServiceProtocolHTTPS) {
+		certificates, err := l.getCertificates(ctx, service)
+		if err != nil {
+			return settings, err
+		}
+
+		settings.http.Certificates = certificates
+	}
+
+	return settings, nil
+}
+
+// parseProtocols parses annotationProtocol.
+func (s *lbServiceSettings) parseProtocols(service *v1.Service) error {
+	entries, ok := getAnnotationStringSlice(service, annotationProtocol)
+	if !ok {
+		return nil
+	}
+
+	for _, entry := range entries {
+		portValue, protocolValue, hasPort := strings.Cut(entry, ":")
+		if !hasPort {
+			protocolValue = portValue
+		}
+
+		protocol := hcloud.LoadBalancerServiceProtocol(protocolValue)
+		switch protocol {
+		case hcloud.LoadBalancerServiceProtocolTCP, hcloud.LoadBalancerServiceProtocolHTTP, hcloud.LoadBalancerServiceProtocolHTTPS:
+		default:
+			return fmt.Errorf("annotation %s: unsupported protocol %q", annotationProtocol, protocolValue)
+		}
+
+		if !hasPort {
+			s.defaultProtocol = protocol
+			continue
+		}
+
+		port, err := strconv.Atoi(portValue)
+		if err != nil {
+			return fmt.Errorf("annotation %s: unable to parse port: %w", annotationProtocol, err)
+		}
+		s.protocols[port] = protocol
+	}
+
+	return nil
+}
+
+// parseProxyProtocol parses annotationUsesProxyProtocol.
+func (s *lbServiceSettings) parseProxyProtocol(service *v1.Service) error {
+	value, ok := getAnnotation(service, annotationUsesProxyProtocol)
+	if !ok {
+		return nil
+	}
+
+	// Only the words are booleans, strconv.ParseBool would also accept "1" and "0", which are ports here
+	switch value {
+	case "true":
+		s.proxyProtocolAll = true
+		return nil
+	case "false":
+		return nil
+	}
+
+	ports, _ := getAnnotationStringSlice(service, annotationUsesProxyProtocol)
+	for _, portValue := range ports {
+		port, err := strconv.Atoi(portValue)
+		if err != nil {
+			return fmt.Errorf("annotation %s: expected a boolean or a list of ports: %w", annotationUsesProxyProtocol, err)
+		}
+		s.proxyProtocolPorts[port] = true
+	}
+
+	return nil
+}
+
+func (s *lbServiceSettings) proxyProtocol(port int) bool {
+	return s.proxyProtocolAll || s.proxyProtocolPorts[port]
+}
+
+func (s *lbServiceSettings) protocol(port int) hcloud.LoadBalancerServiceProtocol {
+	if protocol, ok := s.protocols[port]; ok {
+		return protocol
+	}
+
+	return s.defaultProtocol
+}
+
+// usesProtocol returns whether any port of the Service uses the protocol.
+func (s *lbServiceSettings) usesProtocol(service *v1.Service, protocol hcloud.LoadBalancerServiceProtocol) bool {
+	for _, port := range service.Spec.Ports {
+		if s.protocol(int(port.Port)) == protocol {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getCertificates returns the certificates for HTTPS services, either existing ones referenced by ID or name, or a
+// managed certificate for the configured domains.
+func (l LoadBalancer) getCertificates(ctx context.Context, service *v1.Service) ([]*hcloud.Certificate, error) {
+	references, hasReferences := getAnnotationStringSlice(service, annotationHTTPCertificates)
+	domains, hasDomains := getAnnotationStringSlice(service, annotationHTTPManagedCertificateDomains)
+
+	switch {
+	case hasReferences && hasDomains:
+		return nil, fmt.Errorf("only one of the annotations %s and %s may be set", annotationHTTPCertificates, annotationHTTPManagedCertificateDomains)
+	case hasDomains:
+		certificate, err := l.ensureManagedCertificate(ctx, service, domains)
+		if err != nil {
+			return nil, err
+		}
+		return []*hcloud.Certificate{certificate}, nil
+	case hasReferences:
+		certificates := make([]*hcloud.Certificate, 0, len(references))
+		for _, reference := range references {
+			certificate, _, err := l.client.Certificate.Get(ctx, reference)
+			if err != nil {
+				return nil, fmt.Errorf("unable to get certificate %s: %w", reference, err)
+			}
+			if certificate == nil {
+				return nil, fmt.Errorf("certificate not found: %s", reference)
+			}
+			certificates = append(certificates, certificate)
+		}
+		return certificates, nil
+	default:
+		return nil, fmt.Errorf("https requires one of the annotations %s or %s", annotationHTTPCertificates, annotationHTTPManagedCertificateDomains)
+	}
+}
+
+// ensureManagedCertificate returns the managed certificate for the domains and creates it if it does not exist yet.
+// The domains of a certificate can not be changed, so the name contains a hash of the domains and a new certificate
+// is created when they change.
+func (l LoadBalancer) ensureManagedCertificate(ctx context.Context, service *v1.Service, domains []string) (*hcloud.Certificate, error) {
+	domains = slices.Clone(domains)
+	slices.Sort(domains)
+	hash := sha256.Sum256([]byte(strings.Join(domains, ",")))
+	name := fmt.Sprintf("%s-%s", service.UID, hex.EncodeToString(hash[:])[:8])
+
+	certificate, _, err := l.client.Certificate.GetByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check for existing certificate: %w", err)
+	}
+	if certificate != nil {
+		return certificate, nil
+	}
+
+	// Issuance happens in the background, the Load Balancer can already use the certificate in the meantime
+	result, _, err := l.client.Certificate.CreateCertificate(ctx, hcloud.CertificateCreateOpts{
+		Name:        name,
+		Type:        hcloud.CertificateTypeManaged,
+		DomainNames: domains,
+		Labels:      map[string]string{labelServiceUID: string(service.UID)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create managed certificate: %w", err)
+	}
+
+	return result.Certificate, nil
+}
+
+// cleanupManagedCertificates deletes the managed certificates created for the Service that are no longer in use.
+func (l LoadBalancer) cleanupManagedCertificates(ctx context.Context, service *v1.Service, settings lbServiceSettings) error {
+	certificates, err := l.client.Certificate.AllWithOpts(ctx, hcloud.CertificateListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: fmt.Sprintf("%s=%s", labelServiceUID, service.UID)},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list managed certificates: %w", err)
+	}
+
+	var errs []error
+	for _, certificate := range certificates {
+		if slices.ContainsFunc(settings.http.Certificates, func(c *hcloud.Certificate) bool { return c.ID == certificate.ID }) {
+			continue
+		}
+
+		klog.InfoS("deleting unused managed certificate", "service", klog.KObj(service), "certificate", certificate.Name)
+		if _, err = l.client.Certificate.Delete(ctx, certificate); err != nil {
+			errs = append(errs, fmt.Errorf("unable to delete managed certificate %s: %w", certificate.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// getDesiredLBService returns the desired hcloud Load Balancer service for the port of the Service.
+func getDesiredLBService(service *v1.Service, port v1.ServicePort, settings lbServiceSettings) (hcloud.LoadBalancerService, error) {
+	healthCheck, err := getHealthCheck(service, port)
+	if err != nil {
+		return hcloud.LoadBalancerService{}, err
+	}
+
+	lbService := hcloud.LoadBalancerService{
+		Protocol:        settings.protocol(int(port.Port)),
+		ListenPort:      int(port.Port),
+		DestinationPort: int(port.NodePort),
+		Proxyprotocol:   settings.proxyProtocol(int(port.Port)),
+		HealthCheck:     healthCheck,
+	}
+
+	if lbService.Protocol != hcloud.LoadBalancerServiceProtocolTCP {
+		lbService.HTTP = settings.http
+	}
+	if lbService.Protocol != hcloud.LoadBalancerServiceProtocolHTTPS {
+		// Certificates and the redirect are only valid for HTTPS
+		lbService.HTTP.Certificates = nil
+		lbService.HTTP.RedirectHTTP = false
+	}
+
+	return lbService, nil
+}
+
+// diffLBService returns the names of the fields managed by the CCM that differ between the actual and the desired Load
+// Balancer service.
+func diffLBService(actual, desired hcloud.LoadBalancerService) []string {
+	var fields []string
+
+	if actual.Protocol != desired.Protocol {
+		fields = append(fields, "protocol")
+	}
+	if actual.DestinationPort != desired.DestinationPort {
+		fields = append(fields, "destinationPort")
+	}
+	if actual.Proxyprotocol != desired.Proxyprotocol {
+		fields = append(fields, "proxyprotocol")
+	}
+
+	fields = append(fields, diffHealthCheck(actual.HealthCheck, desired.HealthCheck)...)
+
+	if desired.Protocol == hcloud.LoadBalancerServiceProtocolTCP {
+		// HTTP settings are only relevant for HTTP(S) services
+		return fields
+	}
+
+	if actual.HTTP.StickySessions != desired.HTTP.StickySessions {
+		fields = append(fields, "http.stickySessions")
+	}
+	if actual.HTTP.CookieName != desired.HTTP.CookieName {
+		fields = append(fields, "http.cookieName")
+	}
+	if actual.HTTP.CookieLifetime != desired.HTTP.CookieLifetime {
+		fields = append(fields, "http.cookieLifetime")
+	}
+	if actual.HTTP.RedirectHTTP != desired.HTTP.RedirectHTTP {
+		fields = append(fields, "http.redirectHTTP")
+	}
+	if !slices.Equal(certificateIDs(actual.HTTP.Certificates), certificateIDs(desired.HTTP.Certificates)) {
+		fields = append(fields, "http.certificates")
+	}
+
+	return fields
+}
+
+func addServiceOpts(lbService hcloud.LoadBalancerService) hcloud.LoadBalancerAddServiceOpts {
+	opts := hcloud.LoadBalancerAddServiceOpts{
+		Protocol:        lbService.Protocol,
+		ListenPort:      hcloud.Ptr(lbService.ListenPort),
+		DestinationPort: hcloud.Ptr(lbService.DestinationPort),
+		Proxyprotocol:   hcloud.Ptr(lbService.Proxyprotocol),
+		HealthCheck:     healthCheckAddOpts(lbService.HealthCheck),
+	}
+
+	if lbService.Protocol != hcloud.LoadBalancerServiceProtocolTCP {
+		opts.HTTP = &hcloud.LoadBalancerAddServiceOptsHTTP{
+			CookieName:     hcloud.Ptr(lbService.HTTP.CookieName),
+			CookieLifetime: hcloud.Ptr(lbService.HTTP.CookieLifetime),
+			Certificates:   lbService.HTTP.Certificates,
+			RedirectHTTP:   hcloud.Ptr(lbService.HTTP.RedirectHTTP),
+			StickySessions: hcloud.Ptr(lbService.HTTP.StickySessions),
+		}
+	}
+
+	return opts
+}
+
+func updateServiceOpts(lbService hcloud.LoadBalancerService) hcloud.LoadBalancerUpdateServiceOpts {
+	opts := hcloud.LoadBalancerUpdateServiceOpts{
+		Protocol:        lbService.Protocol,
+		DestinationPort: hcloud.Ptr(lbService.DestinationPort),
+		Proxyprotocol:   hcloud.Ptr(lbService.Proxyprotocol),
+		HealthCheck:     healthCheckUpdateOpts(lbService.HealthCheck),
+	}
+
+	if lbService.Protocol != hcloud.LoadBalancerServiceProtocolTCP {
+		opts.HTTP = &hcloud.LoadBalancerUpdateServiceOptsHTTP{
+			CookieName:     hcloud.Ptr(lbService.HTTP.CookieName),
+			CookieLifetime: hcloud.Ptr(lbService.HTTP.CookieLifetime),
+			Certificates:   lbService.HTTP.Certificates,
+			RedirectHTTP:   hcloud.Ptr(lbService.HTTP.RedirectHTTP),
+			StickySessions: hcloud.Ptr(lbService.HTTP.StickySessions),
+		}
+	}
+
+	return opts
+}
+
+func certificateIDs(certificates []*hcloud.Certificate) []int64 {
+	ids := make([]int64, 0, len(certificates))
+	for _, certificate := range certificates {
+		ids = append(ids, certificate.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,249 @@
+package ccm
+
+import (
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+)
+
+func newAnnotatedService(annotations map[string]string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: annotations},
+	}
+}
+
+func TestParseProtocols(t *testing.T) {
+	tests := []struct {
+		name            string
+		value           *string
+		defaultProtocol hcloud.LoadBalancerServiceProtocol
+		protocols       map[int]hcloud.LoadBalancerServiceProtocol
+		wantErr         bool
+	}{
+		{
+			name:            "not set",
+			defaultProtocol: hcloud.LoadBalancerServiceProtocolTCP,
+			protocols:       map[int]hcloud.LoadBalancerServiceProtocol{},
+		},
+		{
+			name:            "default only",
+			value:           hcloud.Ptr("http"),
+			defaultProtocol: hcloud.LoadBalancerServiceProtocolHTTP,
+			protocols:       map[int]hcloud.LoadBalancerServiceProtocol{},
+		},
+		{
+			name:            "per port and default",
+			value:           hcloud.Ptr("443:https, tcp"),
+			defaultProtocol: hcloud.LoadBalancerServiceProtocolTCP,
+			protocols:       map[int]hcloud.LoadBalancerServiceProtocol{443: hcloud.LoadBalancerServiceProtocolHTTPS},
+		},
+		{
+			name:    "unsupported protocol",
+			value:   hcloud.Ptr("80:udp"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			value:   hcloud.Ptr("web:http"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.value != nil {
+				annotations[annotationProtocol] = *tt.value
+			}
+
+			settings := lbServiceSettings{
+				defaultProtocol: hcloud.LoadBalancerServiceProtocolTCP,
+				protocols:       map[int]hcloud.LoadBalancerServiceProtocol{},
+			}
+			err := settings.parseProtocols(newAnnotatedService(annotations))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if settings.defaultProtocol != tt.defaultProtocol {
+				t.Errorf("expected default protocol %s, got %s", tt.defaultProtocol, settings.defaultProtocol)
+			}
+			if !reflect.DeepEqual(settings.protocols, tt.protocols) {
+				t.Errorf("expected protocols %v, got %v", tt.protocols, settings.protocols)
+			}
+		})
+	}
+}
+
+func TestParseProxyProtocol(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   *string
+		all     bool
+		ports   map[int]bool
+		wantErr bool
+	}{
+		{
+			name:  "not set",
+			ports: map[int]bool{},
+		},
+		{
+			name:  "true",
+			value: hcloud.Ptr("true"),
+			all:   true,
+			ports: map[int]bool{},
+		},
+		{
+			name:  "false",
+			value: hcloud.Ptr("false"),
+			ports: map[int]bool{},
+		},
+		{
+			name:  "1 is a port",
+			value: hcloud.Ptr("1"),
+			ports: map[int]bool{1: true},
+		},
+		{
+			name:    "only true and false are booleans",
+			value:   hcloud.Ptr("TRUE"),
+			wantErr: true,
+		},
+		{
+			name:  "ports",
+			value: hcloud.Ptr("80, 443"),
+			ports: map[int]bool{80: true, 443: true},
+		},
+		{
+			name:    "invalid",
+			value:   hcloud.Ptr("80,https"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.value != nil {
+				annotations[annotationUsesProxyProtocol] = *tt.value
+			}
+
+			settings := lbServiceSettings{proxyProtocolPorts: map[int]bool{}}
+			err := settings.parseProxyProtocol(newAnnotatedService(annotations))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if settings.proxyProtocolAll != tt.all {
+				t.Errorf("expected all ports %t, got %t", tt.all, settings.proxyProtocolAll)
+			}
+			if !reflect.DeepEqual(settings.proxyProtocolPorts, tt.ports) {
+				t.Errorf("expected ports %v, got %v", tt.ports, settings.proxyProtocolPorts)
+			}
+		})
+	}
+}
+
+func TestDiffLBService(t *testing.T) {
+	base := func() hcloud.LoadBalancerService {
+		return hcloud.LoadBalancerService{
+			Protocol:        hcloud.LoadBalancerServiceProtocolHTTP,
+			ListenPort:      80,
+			DestinationPort: 30080,
+			HealthCheck: hcloud.LoadBalancerServiceHealthCheck{
+				Protocol: hcloud.LoadBalancerServiceProtocolHTTP,
+				Port:     30080,
+				Interval: defaultHealthCheckInterval,
+				Timeout:  defaultHealthCheckTimeout,
+				Retries:  defaultHealthCheckRetries,
+				HTTP: &hcloud.LoadBalancerServiceHealthCheckHTTP{
+					Path:        "/healthz",
+					StatusCodes: defaultHealthCheckStatusCodes,
+				},
+			},
+			HTTP: hcloud.LoadBalancerServiceHTTP{
+				CookieName:     defaultHTTPCookieName,
+				CookieLifetime: defaultHTTPCookieLifetime,
+				Certificates:   []*hcloud.Certificate{{ID: 1}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		actual   func(s *hcloud.LoadBalancerService)
+		desired  func(s *hcloud.LoadBalancerService)
+		expected []string
+	}{
+		{
+			name: "equal",
+		},
+		{
+			name: "service fields",
+			actual: func(s *hcloud.LoadBalancerService) {
+				s.Protocol = hcloud.LoadBalancerServiceProtocolTCP
+				s.DestinationPort = 30081
+				s.Proxyprotocol = true
+			},
+			expected: []string{"protocol", "destinationPort", "proxyprotocol"},
+		},
+		{
+			name: "health check fields",
+			actual: func(s *hcloud.LoadBalancerService) {
+				s.HealthCheck.Interval = 30 * defaultHealthCheckInterval
+				s.HealthCheck.HTTP = nil
+			},
+			expected: []string{"healthCheck.interval", "healthCheck.http.path", "healthCheck.http.statusCodes"},
+		},
+		{
+			name: "http fields",
+			actual: func(s *hcloud.LoadBalancerService) {
+				s.HTTP.StickySessions = true
+				s.HTTP.CookieName = "other"
+				s.HTTP.RedirectHTTP = true
+				s.HTTP.Certificates = []*hcloud.Certificate{{ID: 2}}
+			},
+			expected: []string{"http.stickySessions", "http.cookieName", "http.redirectHTTP", "http.certificates"},
+		},
+		{
+			name: "http fields are ignored for tcp",
+			actual: func(s *hcloud.LoadBalancerService) {
+				s.Protocol = hcloud.LoadBalancerServiceProtocolTCP
+				s.HTTP.StickySessions = true
+			},
+			desired: func(s *hcloud.LoadBalancerService) {
+				s.Protocol = hcloud.LoadBalancerServiceProtocolTCP
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, desired := base(), base()
+			if tt.actual != nil {
+				tt.actual(&actual)
+			}
+			if tt.desired != nil {
+				tt.desired(&desired)
+			}
+
+			fields := diffLBService(actual, desired)
+			if !reflect.DeepEqual(fields, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, fields)
+			}
+		})
+	}
+}
@@ -2,82 +2,511 @@ package ccm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	errLBOwnedByOther = errors.New("loadbalancer is owned by another service or cluster")
+	errReservedIPs    = errors.New("loadbalancer does not have the reserved IPs")
+)
+
+const (
+	eventReasonNodeNotTargeted          = "NodeNotTargeted"
+	eventReasonSourceRangesNotSupported = "SourceRangesNotSupported"
+	eventReasonListenPortConflict       = "ListenPortConflict"
 )
 
 type LoadBalancer struct {
-	client    *hcloud.Client
-	networkID int64
+	client     *hcloud.Client
+	networkID  int64
+	config     LoadBalancerConfig
+	recorder   record.EventRecorder
+	kubeClient kubernetes.Interface
+	endpoints  *endpointsWatcher
+	locks      *serviceLocks
+	// clusterName is the cluster name from the cloud config, used by the endpoints watcher.
+	clusterName string
+}
+
+// warnf logs the message and emits it as a warning event on the Service.
+func (l LoadBalancer) warnf(service *v1.Service, reason, format string, args ...any) {
+	klog.InfoS(fmt.Sprintf(format, args...), "service", klog.KObj(service), "reason", reason)
+	if l.recorder != nil {
+		l.recorder.Eventf(service, v1.EventTypeWarning, reason, format, args...)
+	}
 }
 
 func (l LoadBalancer) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
-	lbName := getLBName(clusterName, service)
-	lb, _, err := l.client.LoadBalancer.GetByName(ctx, lbName)
+	lb, err := l.getLoadBalancer(ctx, clusterName, service)
 	if err != nil {
-		return nil, false, fmt.Errorf("unable to check for existing loadbalancer: %w", err)
+		if errors.Is(err, errLBOwnedByOther) {
+			return nil, false, nil
+		}
+		return nil, false, err
 	}
 
 	if lb == nil {
 		return nil, false, nil
 	}
 
-	return getLBStatus(lb), true, nil
+	status, err = l.getLBStatus(service, lb)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return status, true, nil
 }
 
-func (l LoadBalancer) GetLoadBalancerName(_ context.Context, clusterName string, service *v1.Service) string {
-	return getLBName(clusterName, service)
+func (l LoadBalancer) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	// Existing loadbalancers and loadbalancers created with an older name template have a different name
+	lb, err := l.getLoadBalancer(ctx, clusterName, service)
+	if err == nil && lb != nil {
+		return lb.Name
+	}
+
+	return l.getLBName(clusterName, service)
 }
 
 func (l LoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	unlock := l.locks.lock(service.UID)
+	defer unlock()
+
 	// Get existing LoadBalancer
-	lbName := getLBName(clusterName, service)
-	lb, _, err := l.client.LoadBalancer.GetByName(ctx, lbName)
+	lb, err := l.getLoadBalancer(ctx, clusterName, service)
 	if err != nil {
-		return nil, fmt.Errorf("unable to check for existing loadbalancer: %w", err)
+		return nil, err
 	}
 
 	if lb == nil {
-		// (If none) create new LoadBalancer
-		result, _, err := l.client.LoadBalancer.Create(ctx, hcloud.LoadBalancerCreateOpts{
-			Name:             lbName,
-			LoadBalancerType: &hcloud.LoadBalancerType{ID: 1, Name: "lb11"},
-			Location:         &hcloud.Location{ID: 1, Name: "fsn1"},
-			Network:          &hcloud.Network{ID: l.networkID},
+		if isExistingLB(service) {
+			return nil, fmt.Errorf("existing loadbalancer not found: %s", service.Annotations[annotationLBExisting])
+		}
+
+		ipv4, ipv6, err := getReservedIPs(service)
+		if err != nil {
+			return nil, err
+		}
+		if ipv4 != nil || ipv6 != nil {
+			// New loadbalancers get IPs assigned by hcloud, so the reserved IPs can only come from a retained one
+			lb, err = l.findLBByReservedIPs(ctx, clusterName, ipv4, ipv6)
+			if err != nil {
+				return nil, err
+			}
+			if lb == nil {
+				return nil, fmt.Errorf("%w: no loadbalancer with the reserved IPs retained by this cluster found", errReservedIPs)
+			}
+			klog.InfoS("adopting loadbalancer with the reserved IPs", "service", klog.KObj(service), "loadbalancer", lb.Name)
+		} else {
+			// (If none) create new LoadBalancer
+			lb, err = l.createLB(ctx, clusterName, service)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = checkReservedIPs(service, lb); err != nil {
+		return nil, err
+	}
+
+	lb, err = l.reconcileLB(ctx, clusterName, service, nodes, lb)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.getLBStatus(service, lb)
+}
+
+func (l LoadBalancer) createLB(ctx context.Context, clusterName string, service *v1.Service) (*hcloud.LoadBalancer, error) {
+	lbType, location, networkZone, err := l.getLBPlacement(service)
+	if err != nil {
+		return nil, err
+	}
+
+	publicInterface, privateIP, err := l.getLBInterfaces(service)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := getLBAlgorithm(service)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := hcloud.LoadBalancerCreateOpts{
+		Name:             l.getLBName(clusterName, service),
+		Labels:           getLBLabels(clusterName, service),
+		LoadBalancerType: &hcloud.LoadBalancerType{Name: lbType},
+		Algorithm:        &hcloud.LoadBalancerAlgorithm{Type: algorithm},
+		NetworkZone:      hcloud.NetworkZone(networkZone),
+		// The public interface can only be disabled while the loadbalancer is attached to a network. If it is attached
+		// after the create call, reconcileLB disables the public interface afterwards.
+		PublicInterface: hcloud.Ptr(publicInterface || privateIP != nil),
+	}
+	if location != "" {
+		opts.Location = &hcloud.Location{Name: location}
+	}
+	if l.networkID != 0 && privateIP == nil {
+		// The create call can not select the private IP, so the loadbalancer is attached afterwards in that case
+		opts.Network = &hcloud.Network{ID: l.networkID}
+	}
+
+	result, _, err := l.client.LoadBalancer.Create(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create new loadbalancer: %w", err)
+	}
+
+	// Wait for IP to be set
+	_, errCh := l.client.Action.WatchProgress(ctx, result.Action)
+	err = <-errCh
+	if err != nil {
+		return nil, fmt.Errorf("unable to start new loadbalancer: %w", err)
+	}
+
+	if privateIP != nil {
+		action, _, err := l.client.LoadBalancer.AttachToNetwork(ctx, result.LoadBalancer, hcloud.LoadBalancerAttachToNetworkOpts{
+			Network: &hcloud.Network{ID: l.networkID},
+			IP:      privateIP,
 		})
 		if err != nil {
-			return nil, fmt.Errorf("unable to create new loadbalancer: %w", err)
+			return nil, fmt.Errorf("unable to attach new loadbalancer to network: %w", err)
 		}
 
-		// Wait for IP to be set
-		_, errCh := l.client.Action.WatchProgress(ctx, result.Action)
-		err = <-errCh
+		if err = waitForAction(ctx, l.client, action); err != nil {
+			return nil, fmt.Errorf("unable to attach new loadbalancer to network: %w", err)
+		}
+	}
+
+	lb, _, err := l.client.LoadBalancer.GetByID(ctx, result.LoadBalancer.ID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to refresh new loadbalancer: %w", err)
+	}
+
+	return lb, nil
+}
+
+// reconcileLB updates the settings, services and targets of the Load Balancer to match the Service and nodes. It is
+// shared by EnsureLoadBalancer and UpdateLoadBalancer and returns the refreshed Load Balancer.
+func (l LoadBalancer) reconcileLB(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, lb *hcloud.LoadBalancer) (*hcloud.LoadBalancer, error) {
+	// The endpoints watcher and the firewall controller use the cluster name from the cloud config, they would not find
+	// the Load Balancers otherwise
+	if clusterName != l.clusterName {
+		return nil, fmt.Errorf("cluster name %q does not match clusterName %q of the cloud config", clusterName, l.clusterName)
+	}
+
+	// hcloud Firewalls can not be applied to Load Balancers, and the nodes only see the IPs of the Load Balancer, so
+	// the source ranges can not be enforced anywhere
+	if len(service.Spec.LoadBalancerSourceRanges) > 0 || service.Annotations[v1.AnnotationLoadBalancerSourceRangesKey] != "" {
+		l.warnf(service, eventReasonSourceRangesNotSupported,
+			"loadBalancerSourceRanges are not supported by hcloud Load Balancers, traffic from all sources is allowed")
+	}
+
+	// Existing loadbalancers are managed by someone else, only their services and targets are reconciled
+	if !isExistingLB(service) {
+		// Adopt loadbalancers that were found by name and apply name changes
+		if err := l.ensureLBNameAndLabels(ctx, l.getLBName(clusterName, service), clusterName, service, lb); err != nil {
+			return nil, err
+		}
+
+		if err := l.reconcileLBType(ctx, service, lb); err != nil {
+			return nil, err
+		}
+
+		// The public interface can only be disabled while the loadbalancer is attached to a network
+		if err := l.reconcileLBNetwork(ctx, service, lb); err != nil {
+			return nil, err
+		}
+
+		if err := l.reconcileLBPublicInterface(ctx, service, lb); err != nil {
+			return nil, err
+		}
+
+		if err := l.reconcileLBAlgorithm(ctx, service, lb); err != nil {
+			return nil, err
+		}
+
+		if err := l.reconcileLBProtection(ctx, service, lb); err != nil {
+			return nil, err
+		}
+	}
+
+	if isExistingLB(service) {
+		if err := l.claimExistingLB(ctx, service, lb); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.reconcileLBServices(ctx, service, lb); err != nil {
+		return nil, err
+	}
+
+	if err := l.updateLBTargets(ctx, clusterName, service, nodes, lb); err != nil {
+		return nil, err
+	}
+
+	lb, _, err := l.client.LoadBalancer.GetByID(ctx, lb.ID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to refresh loadbalancer: %w", err)
+	}
+	if lb == nil {
+		return nil, fmt.Errorf("loadbalancer was deleted during reconcile")
+	}
+
+	return lb, nil
+}
+
+func (l LoadBalancer) reconcileLBType(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	lbType, _, _, err := l.getLBPlacement(service)
+	if err != nil {
+		return err
+	}
+
+	if lb.LoadBalancerType.Name == lbType {
+		return nil
+	}
+
+	// Location and network zone can not be changed, but the type can be changed in place
+	action, _, err := l.client.LoadBalancer.ChangeType(ctx, lb, hcloud.LoadBalancerChangeTypeOpts{
+		LoadBalancerType: &hcloud.LoadBalancerType{Name: lbType},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to change loadbalancer type: %w", err)
+	}
+
+	if err = waitForAction(ctx, l.client, action); err != nil {
+		return fmt.Errorf("unable to change loadbalancer type: %w", err)
+	}
+
+	return nil
+}
+
+func (l LoadBalancer) reconcileLBPublicInterface(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	publicInterface, _, err := l.getLBInterfaces(service)
+	if err != nil {
+		return err
+	}
+
+	if lb.PublicNet.Enabled == publicInterface {
+		return nil
+	}
+
+	var action *hcloud.Action
+	if publicInterface {
+		action, _, err = l.client.LoadBalancer.EnablePublicInterface(ctx, lb)
+	} else {
+		action, _, err = l.client.LoadBalancer.DisablePublicInterface(ctx, lb)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to change public interface of loadbalancer: %w", err)
+	}
+
+	if err = waitForAction(ctx, l.client, action); err != nil {
+		return fmt.Errorf("unable to change public interface of loadbalancer: %w", err)
+	}
+
+	lb.PublicNet.Enabled = publicInterface
+	return nil
+}
+
+// reconcileLBNetwork attaches the Load Balancer to the configured network if it is not attached. If the configured
+// network changed, the Load Balancer is detached from the network the CCM attached it to before. The interfaces are
+// validated first, so the Load Balancer is never left without any interface.
+func (l LoadBalancer) reconcileLBNetwork(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	_, privateIP, err := l.getLBInterfaces(service)
+	if err != nil {
+		return err
+	}
+
+	attached := false
+	for _, privateNet := range lb.PrivateNet {
+		if privateNet.Network.ID == l.networkID {
+			attached = true
+		}
+	}
+
+	if l.networkID != 0 && !attached {
+		klog.InfoS("attaching loadbalancer to network", "service", klog.KObj(service), "loadBalancer", lb.Name, "network", l.networkID)
+
+		action, _, err := l.client.LoadBalancer.AttachToNetwork(ctx, lb, hcloud.LoadBalancerAttachToNetworkOpts{
+			Network: &hcloud.Network{ID: l.networkID},
+			IP:      privateIP,
+		})
 		if err != nil {
-			return nil, fmt.Errorf("unable to start new loadbalancer: %w", err)
+			return fmt.Errorf("unable to attach loadbalancer to network: %w", err)
+		}
+
+		if err = waitForAction(ctx, l.client, action); err != nil {
+			return fmt.Errorf("unable to attach loadbalancer to network: %w", err)
 		}
+	}
+
+	// Networks the loadbalancer was attached to by someone else are kept
+	if previousID, err := strconv.ParseInt(lb.Labels[labelNetwork], 10, 64); err == nil && previousID != l.networkID {
+		for _, privateNet := range lb.PrivateNet {
+			if privateNet.Network.ID != previousID {
+				continue
+			}
+
+			// Without a new network the previous one may be the only interface, the public one has to be enabled first
+			if !lb.PublicNet.Enabled {
+				if err = l.reconcileLBPublicInterface(ctx, service, lb); err != nil {
+					return err
+				}
+			}
+
+			klog.InfoS("detaching loadbalancer from previous network", "service", klog.KObj(service), "loadBalancer", lb.Name, "network", previousID)
 
-		lb, _, err = l.client.LoadBalancer.GetByID(ctx, result.LoadBalancer.ID)
+			action, _, err := l.client.LoadBalancer.DetachFromNetwork(ctx, lb, hcloud.LoadBalancerDetachFromNetworkOpts{
+				Network: privateNet.Network,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to detach loadbalancer from network: %w", err)
+			}
+
+			if err = waitForAction(ctx, l.client, action); err != nil {
+				return fmt.Errorf("unable to detach loadbalancer from network: %w", err)
+			}
+		}
+	}
+
+	return l.updateLBLabels(ctx, lb, func(labels map[string]string) {
+		if l.networkID == 0 {
+			delete(labels, labelNetwork)
+		} else {
+			labels[labelNetwork] = strconv.FormatInt(l.networkID, 10)
+		}
+	})
+}
+
+func (l LoadBalancer) reconcileLBAlgorithm(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	algorithm, err := getLBAlgorithm(service)
+	if err != nil {
+		return err
+	}
+
+	if lb.Algorithm.Type == algorithm {
+		return nil
+	}
+
+	klog.InfoS("changing algorithm of loadbalancer", "service", klog.KObj(service), "loadBalancer", lb.Name,
+		"from", lb.Algorithm.Type, "to", algorithm)
+
+	action, _, err := l.client.LoadBalancer.ChangeAlgorithm(ctx, lb, hcloud.LoadBalancerChangeAlgorithmOpts{Type: algorithm})
+	if err != nil {
+		return fmt.Errorf("unable to change loadbalancer algorithm: %w", err)
+	}
+
+	if err = waitForAction(ctx, l.client, action); err != nil {
+		return fmt.Errorf("unable to change loadbalancer algorithm: %w", err)
+	}
+
+	return nil
+}
+
+func (l LoadBalancer) reconcileLBProtection(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	protected, ok, err := getAnnotationBool(service, annotationLBProtected)
+	if err != nil {
+		return err
+	}
+
+	_, setByCCM := lb.Labels[labelProtected]
+	if !ok {
+		if !setByCCM {
+			// Leave protection that was enabled outside of the CCM alone
+			return nil
+		}
+		protected = false
+	}
+
+	if lb.Protection.Delete != protected {
+		klog.InfoS("changing delete protection of loadbalancer", "service", klog.KObj(service), "loadBalancer", lb.Name, "protected", protected)
+
+		action, _, err := l.client.LoadBalancer.ChangeProtection(ctx, lb, hcloud.LoadBalancerChangeProtectionOpts{Delete: hcloud.Ptr(protected)})
 		if err != nil {
-			return nil, fmt.Errorf("unable to refresh new loadbalancer: %w", err)
+			return fmt.Errorf("unable to change loadbalancer protection: %w", err)
 		}
+
+		if err = waitForAction(ctx, l.client, action); err != nil {
+			return fmt.Errorf("unable to change loadbalancer protection: %w", err)
+		}
+		lb.Protection.Delete = protected
+	}
+
+	return l.updateLBLabels(ctx, lb, func(labels map[string]string) {
+		if protected {
+			labels[labelProtected] = "true"
+		} else {
+			delete(labels, labelProtected)
+		}
+	})
+}
+
+func getLBAlgorithm(service *v1.Service) (hcloud.LoadBalancerAlgorithmType, error) {
+	value, ok := getAnnotation(service, annotationLBAlgorithmType)
+	if !ok {
+		return hcloud.LoadBalancerAlgorithmTypeRoundRobin, nil
+	}
+
+	switch algorithm := hcloud.LoadBalancerAlgorithmType(value); algorithm {
+	case hcloud.LoadBalancerAlgorithmTypeRoundRobin, hcloud.LoadBalancerAlgorithmTypeLeastConnections:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("annotation %s: unsupported algorithm %q", annotationLBAlgorithmType, value)
+	}
+}
+
+func (l LoadBalancer) reconcileLBServices(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	settings, err := l.getLBServiceSettings(ctx, service)
+	if err != nil {
+		return err
+	}
+
+	// Record of the services that were added by the CCM to an existing loadbalancer, nil for all others
+	state, err := l.getExistingLBState(ctx, service, lb)
+	if err != nil {
+		return err
 	}
 
 	// Check the services
 	// Create missing hcloud lb services
 	for _, port := range service.Spec.Ports {
+		desired, err := getDesiredLBService(service, port, settings)
+		if err != nil {
+			return err
+		}
+
 		foundExistingService := false
 		for _, svc := range lb.Services {
 			if svc.ListenPort == int(port.Port) {
 				foundExistingService = true
+
+				if state != nil && !state.hasPort(svc.ListenPort) {
+					// The listen port is used by a service someone else added, never overwrite it
+					l.warnf(service, eventReasonListenPortConflict,
+						"Port %d is not added: loadbalancer %s already has a service on this port that was not added by the CCM", svc.ListenPort, lb.Name)
+					break
+				}
+
 				// Match
-				if svc.DestinationPort != int(port.NodePort) {
-					_, _, err = l.client.LoadBalancer.UpdateService(ctx, lb, svc.ListenPort, hcloud.LoadBalancerUpdateServiceOpts{
-						Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
-						DestinationPort: hcloud.Ptr(int(port.NodePort)),
-					})
+				// Update all drifted fields in a single call
+				if changed := diffLBService(svc, desired); len(changed) > 0 {
+					klog.InfoS("correcting drift of load balancer service", "service", klog.KObj(service),
+						"loadBalancer", lb.Name, "listenPort", svc.ListenPort, "fields", changed)
+
+					_, _, err = l.client.LoadBalancer.UpdateService(ctx, lb, svc.ListenPort, updateServiceOpts(desired))
 					if err != nil {
-						return nil, err
+						return fmt.Errorf("unable to update loadbalancer service %d: %w", svc.ListenPort, err)
 					}
 				}
 				break
@@ -86,13 +515,16 @@ func (l LoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string
 
 		if !foundExistingService {
 			// No existing service found
-			_, _, err = l.client.LoadBalancer.AddService(ctx, lb, hcloud.LoadBalancerAddServiceOpts{
-				Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
-				ListenPort:      hcloud.Ptr(int(port.Port)),
-				DestinationPort: hcloud.Ptr(int(port.NodePort)),
-			})
+			_, _, err = l.client.LoadBalancer.AddService(ctx, lb, addServiceOpts(desired))
 			if err != nil {
-				return nil, err
+				return err
+			}
+
+			if state != nil {
+				state.setPort(int(port.Port), true)
+				if err = l.saveExistingLBState(ctx, service, state); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -107,22 +539,111 @@ serviceLoop:
 			}
 		}
 
+		if state != nil && !state.hasPort(svc.ListenPort) {
+			// Not added by us
+			continue
+		}
+
 		// Havent found a match
 		_, _, err = l.client.LoadBalancer.DeleteService(ctx, lb, svc.ListenPort)
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		if state != nil {
+			state.setPort(svc.ListenPort, false)
+			if err = l.saveExistingLBState(ctx, service, state); err != nil {
+				return err
+			}
 		}
 	}
 
-	status, err := l.updateLBTargets(ctx, nodes, lb)
+	// Certificates can only be deleted once no service uses them anymore
+	if err = l.cleanupManagedCertificates(ctx, service, settings); err != nil {
+		klog.ErrorS(err, "unable to clean up managed certificates", "service", klog.KObj(service))
+	}
+
+	return nil
+}
+
+// updateLBTargets adds the nodes as targets to the Load Balancer and removes all targets of nodes that are gone. hcloud
+// servers are added either as server targets or through a single label selector target, all other nodes as IP targets.
+func (l LoadBalancer) updateLBTargets(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, lb *hcloud.LoadBalancer) error {
+	targetType, err := getTargetType(service)
 	if err != nil {
-		return status, err
+		return err
 	}
 
-	return getLBStatus(lb), nil
+	nodes, err = filterNodes(service, nodes, targetType)
+	if err != nil {
+		return err
+	}
+
+	nodes, err = l.filterNodesWithEndpoints(service, nodes, targetType)
+	if err != nil {
+		return err
+	}
+
+	usePrivateIP, err := l.usePrivateIP(service)
+	if err != nil {
+		return err
+	}
+
+	serverNodes, ips := l.splitNodes(service, nodes)
+
+	// Record of the targets that were added by the CCM to an existing loadbalancer, nil for all others
+	state, err := l.getExistingLBState(ctx, service, lb)
+	if err != nil {
+		return err
+	}
+
+	if targetType == targetTypeLabelSelector {
+		// Remove the server targets first, servers that are already targets can not be added through a label selector
+		if err = l.updateLBServerTargets(ctx, service, nil, lb, usePrivateIP, state); err != nil {
+			return err
+		}
+		if err = l.updateLBLabelSelectorTarget(ctx, clusterName, service, serverNodes, lb, usePrivateIP); err != nil {
+			return err
+		}
+	} else {
+		if err = l.removeManagedLabelSelectorTargets(ctx, clusterName, lb); err != nil {
+			return err
+		}
+		if err = l.updateLBServerTargets(ctx, service, serverNodes, lb, usePrivateIP, state); err != nil {
+			return err
+		}
+	}
+
+	return l.updateLBIPTargets(ctx, service, ips, lb, state)
+}
+
+// usePrivateIP returns whether the Load Balancer reaches its targets through the private network.
+func (l LoadBalancer) usePrivateIP(service *v1.Service) (bool, error) {
+	// Without a network the targets are only reachable through their public IPs
+	usePrivateIP := l.networkID != 0
+	if l.config.UsePrivateIP != nil {
+		usePrivateIP = *l.config.UsePrivateIP
+	}
+
+	value, ok, err := getAnnotationBool(service, annotationUsePrivateIP)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		usePrivateIP = value
+	}
+
+	if usePrivateIP && l.networkID == 0 {
+		return false, fmt.Errorf("targets can only be reached through their private IPs if a network is configured")
+	}
+
+	return usePrivateIP, nil
 }
 
-func (l LoadBalancer) updateLBTargets(ctx context.Context, nodes []*v1.Node, lb *hcloud.LoadBalancer) (*v1.LoadBalancerStatus, error) {
+// updateLBServerTargets adds the nodes as server targets and removes all other server targets. state is the record of an
+// existing Load Balancer, only server targets recorded there are changed or removed.
+func (l LoadBalancer) updateLBServerTargets(ctx context.Context, service *v1.Service, nodes []*v1.Node, lb *hcloud.LoadBalancer, usePrivateIP bool, state *existingLBState) error {
+
 	// Check the targets
 	// Create missing hcloud lb targets
 	for _, node := range nodes {
@@ -130,12 +651,23 @@ func (l LoadBalancer) updateLBTargets(ctx context.Context, nodes []*v1.Node, lb
 
 		providerID, err := getProviderID(node)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		for _, target := range lb.Targets {
-			if target.Server.Server.ID == providerID {
+			if target.Type == hcloud.LoadBalancerTargetTypeServer && target.Server.Server.ID == providerID {
 				foundExistingTarget = true
+
+				// Targets of existing loadbalancers that were not added by us are kept as they are
+				if target.UsePrivateIP != usePrivateIP && (state == nil || state.hasServer(providerID)) {
+					klog.InfoS("recreating target to change use_private_ip", "service", klog.KObj(service),
+						"server", providerID, "usePrivateIP", usePrivateIP)
+
+					if err = l.removeServerTarget(ctx, lb, target.Server.Server); err != nil {
+						return err
+					}
+					foundExistingTarget = false
+				}
 				break
 			}
 		}
@@ -145,10 +677,17 @@ func (l LoadBalancer) updateLBTargets(ctx context.Context, nodes []*v1.Node, lb
 				Server: &hcloud.Server{
 					ID: providerID,
 				},
-				UsePrivateIP: hcloud.Ptr(true),
+				UsePrivateIP: hcloud.Ptr(usePrivateIP),
 			})
 			if err != nil {
-				return nil, err
+				return err
+			}
+
+			if state != nil {
+				state.setServer(providerID, true)
+				if err = l.saveExistingLBState(ctx, service, state); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -156,10 +695,19 @@ func (l LoadBalancer) updateLBTargets(ctx context.Context, nodes []*v1.Node, lb
 	// Cleanup targets in hetzner cloud api that we no longer need
 targetLoop:
 	for _, target := range lb.Targets {
+		if target.Type != hcloud.LoadBalancerTargetTypeServer {
+			continue
+		}
+
+		if state != nil && !state.hasServer(target.Server.Server.ID) {
+			// Not added by us
+			continue
+		}
+
 		for _, node := range nodes {
 			providerID, err := getProviderID(node)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			if target.Server.Server.ID == providerID {
@@ -168,74 +716,484 @@ targetLoop:
 			}
 		}
 
-		// Havent found a match
-		_, _, err := l.client.LoadBalancer.RemoveServerTarget(ctx, lb, target.Server.Server)
+		// Havent found a match. Wait for the removal, the server may be added through a label selector target next.
+		err := l.removeServerTarget(ctx, lb, target.Server.Server)
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		if state != nil {
+			state.setServer(target.Server.Server.ID, false)
+			if err = l.saveExistingLBState(ctx, service, state); err != nil {
+				return err
+			}
 		}
 	}
 
+	return nil
+}
+
+// removeServerTarget removes the server target and waits until it is gone, so the server can be added again right
+// away.
+func (l LoadBalancer) removeServerTarget(ctx context.Context, lb *hcloud.LoadBalancer, server *hcloud.Server) error {
+	action, _, err := l.client.LoadBalancer.RemoveServerTarget(ctx, lb, server)
+	if err != nil {
+		return fmt.Errorf("unable to remove target %d: %w", server.ID, err)
+	}
+
+	if err = waitForAction(ctx, l.client, action); err != nil {
+		return fmt.Errorf("unable to remove target %d: %w", server.ID, err)
+	}
+
+	return nil
+}
+
+// getReservedIPs returns the public IPs the Load Balancer of the Service must have, or nil if none are reserved.
+func getReservedIPs(service *v1.Service) (ipv4, ipv6 net.IP, err error) {
+	if value, ok := getAnnotation(service, annotationPublicIPv4); ok {
+		if ipv4 = net.ParseIP(value).To4(); ipv4 == nil {
+			return nil, nil, fmt.Errorf("annotation %s: not an IPv4 address: %q", annotationPublicIPv4, value)
+		}
+	}
+
+	if value, ok := getAnnotation(service, annotationPublicIPv6); ok {
+		if ipv6 = net.ParseIP(value); ipv6 == nil || ipv6.To4() != nil {
+			return nil, nil, fmt.Errorf("annotation %s: not an IPv6 address: %q", annotationPublicIPv6, value)
+		}
+	}
+
+	return ipv4, ipv6, nil
+}
+
+// checkReservedIPs returns an error if the Load Balancer does not have the public IPs reserved for the Service. The
+// status of the Service then keeps the reserved IPs instead of publishing different ones.
+func checkReservedIPs(service *v1.Service, lb *hcloud.LoadBalancer) error {
+	ipv4, ipv6, err := getReservedIPs(service)
+	if err != nil {
+		return err
+	}
+
+	if ipv4 != nil && !ipv4.Equal(lb.PublicNet.IPv4.IP) {
+		return fmt.Errorf("%w: expected IPv4 %s, got %s", errReservedIPs, ipv4, lb.PublicNet.IPv4.IP)
+	}
+	if ipv6 != nil && !ipv6.Equal(lb.PublicNet.IPv6.IP) {
+		return fmt.Errorf("%w: expected IPv6 %s, got %s", errReservedIPs, ipv6, lb.PublicNet.IPv6.IP)
+	}
+
+	return nil
+}
+
+// findLBByReservedIPs returns the Load Balancer with the reserved IPs that this cluster retained after its Service was
+// deleted, or nil if there is none. Load Balancers of other clusters or not created by the CCM are never adopted.
+func (l LoadBalancer) findLBByReservedIPs(ctx context.Context, clusterName string, ipv4, ipv6 net.IP) (*hcloud.LoadBalancer, error) {
+	lbs, err := l.client.LoadBalancer.AllWithOpts(ctx, hcloud.LoadBalancerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: fmt.Sprintf("%s=%s,%s,!%s", labelCluster, clusterName, labelRetained, labelServiceUID),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list loadbalancers: %w", err)
+	}
+
+	for _, lb := range lbs {
+		if ipv4 != nil && !ipv4.Equal(lb.PublicNet.IPv4.IP) {
+			continue
+		}
+		if ipv6 != nil && !ipv6.Equal(lb.PublicNet.IPv6.IP) {
+			continue
+		}
+		return lb, nil
+	}
+
 	return nil, nil
 }
 
-func getLBStatus(lb *hcloud.LoadBalancer) *v1.LoadBalancerStatus {
+// getLBPlacement returns the type and either the location or the network zone for the Load Balancer. Annotations on the
+// Service take precedence over the defaults from the cloud config.
+func (l LoadBalancer) getLBPlacement(service *v1.Service) (lbType, location, networkZone string, err error) {
+	lbType = l.config.Type
+	if value, ok := getAnnotation(service, annotationLBType); ok {
+		lbType = value
+	}
+
+	annotationLocation, hasLocation := getAnnotation(service, annotationLBLocation)
+	annotationNetworkZone, hasNetworkZone := getAnnotation(service, annotationLBNetworkZone)
+
+	switch {
+	case hasLocation && hasNetworkZone:
+		return "", "", "", fmt.Errorf("only one of the annotations %s and %s may be set", annotationLBLocation, annotationLBNetworkZone)
+	case hasLocation:
+		return lbType, annotationLocation, "", nil
+	case hasNetworkZone:
+		return lbType, "", annotationNetworkZone, nil
+	default:
+		return lbType, l.config.Location, l.config.NetworkZone, nil
+	}
+}
+
+// getLBInterfaces returns whether the public interface of the Load Balancer is enabled, and the IP it should get in the
+// private network, if one was selected.
+func (l LoadBalancer) getLBInterfaces(service *v1.Service) (publicInterface bool, privateIP net.IP, err error) {
+	disablePublic, _, err := getAnnotationBool(service, annotationDisablePublicNetwork)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if value, ok := getAnnotation(service, annotationPrivateIPv4); ok {
+		privateIP = net.ParseIP(value)
+		if privateIP == nil || privateIP.To4() == nil {
+			return false, nil, fmt.Errorf("annotation %s: not a valid IPv4 address: %s", annotationPrivateIPv4, value)
+		}
+	}
+
+	if l.networkID == 0 && (disablePublic || privateIP != nil) {
+		return false, nil, fmt.Errorf("annotations %s and %s require a network in the cloud config", annotationDisablePublicNetwork, annotationPrivateIPv4)
+	}
+
+	return !disablePublic, privateIP, nil
+}
+
+func (l LoadBalancer) getLBStatus(service *v1.Service, lb *hcloud.LoadBalancer) (*v1.LoadBalancerStatus, error) {
 	lbStatus := &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{}}
 
-	if lb.PublicNet.IPv4.IP != nil && !lb.PublicNet.IPv4.IP.IsUnspecified() {
-		lbStatus.Ingress = append(lbStatus.Ingress, v1.LoadBalancerIngress{
-			IP:       lb.PublicNet.IPv4.IP.String(),
-			Hostname: lb.PublicNet.IPv4.DNSPtr,
-		})
+	ipMode, err := getIPMode(service, lb)
+	if err != nil {
+		return nil, err
+	}
+
+	if !lb.PublicNet.Enabled {
+		// Private-only loadbalancers are reachable through their IP in our network
+		for _, privateNet := range lb.PrivateNet {
+			if privateNet.Network.ID == l.networkID {
+				lbStatus.Ingress = append(lbStatus.Ingress, v1.LoadBalancerIngress{
+					IP:     privateNet.IP.String(),
+					IPMode: ipMode,
+				})
+			}
+		}
+
+		return lbStatus, nil
+	}
+
+	families, err := getIPFamilies(service)
+	if err != nil {
+		return nil, err
+	}
+
+	useHostname, _, err := getAnnotationBool(service, annotationUseHostname)
+	if err != nil {
+		return nil, err
 	}
 
-	if lb.PublicNet.IPv6.IP != nil && !lb.PublicNet.IPv6.IP.IsUnspecified() {
+	type publicIP struct {
+		family v1.IPFamily
+		ip     net.IP
+		dnsPtr string
+	}
+
+	for _, address := range []publicIP{
+		{v1.IPv4Protocol, lb.PublicNet.IPv4.IP, lb.PublicNet.IPv4.DNSPtr},
+		{v1.IPv6Protocol, lb.PublicNet.IPv6.IP, lb.PublicNet.IPv6.DNSPtr},
+	} {
+		if !families[address.family] || address.ip == nil || address.ip.IsUnspecified() {
+			continue
+		}
+
+		if useHostname {
+			// Both addresses usually share the same hostname
+			if address.dnsPtr != "" && !slices.ContainsFunc(lbStatus.Ingress, func(i v1.LoadBalancerIngress) bool { return i.Hostname == address.dnsPtr }) {
+				lbStatus.Ingress = append(lbStatus.Ingress, v1.LoadBalancerIngress{Hostname: address.dnsPtr})
+			}
+			continue
+		}
+
 		lbStatus.Ingress = append(lbStatus.Ingress, v1.LoadBalancerIngress{
-			IP:       lb.PublicNet.IPv6.IP.String(),
-			Hostname: lb.PublicNet.IPv6.DNSPtr,
+			IP:       address.ip.String(),
+			Hostname: address.dnsPtr,
+			IPMode:   ipMode,
 		})
 	}
 
-	return lbStatus
+	return lbStatus, nil
+}
+
+// getIPMode returns how kube-proxy should treat the ingress IPs. With the PROXY protocol or HTTP(S) the loadbalancer is
+// not transparent, so traffic from inside the cluster must be sent through it instead of being short-circuited. VIP is
+// returned as nil, which means the same. The service controller compares the ipMode by pointer, so a set VIP would
+// look like a changed status on every sync.
+func getIPMode(service *v1.Service, lb *hcloud.LoadBalancer) (*v1.LoadBalancerIPMode, error) {
+	ipMode := v1.LoadBalancerIPModeVIP
+	if value, ok := getAnnotation(service, annotationIPMode); ok {
+		switch ipMode = v1.LoadBalancerIPMode(value); ipMode {
+		case v1.LoadBalancerIPModeVIP, v1.LoadBalancerIPModeProxy:
+		default:
+			return nil, fmt.Errorf("annotation %s: unsupported ip mode %q", annotationIPMode, value)
+		}
+	} else {
+		for _, svc := range lb.Services {
+			if svc.Proxyprotocol || svc.Protocol != hcloud.LoadBalancerServiceProtocolTCP {
+				ipMode = v1.LoadBalancerIPModeProxy
+				break
+			}
+		}
+	}
+
+	if ipMode == v1.LoadBalancerIPModeVIP {
+		return nil, nil
+	}
+	return &ipMode, nil
+}
+
+// getIPFamilies returns the IP families that are published in the status of the Service.
+func getIPFamilies(service *v1.Service) (map[v1.IPFamily]bool, error) {
+	if values, ok := getAnnotationStringSlice(service, annotationIPFamilies); ok {
+		families := map[v1.IPFamily]bool{}
+		for _, value := range values {
+			switch {
+			case strings.EqualFold(value, string(v1.IPv4Protocol)):
+				families[v1.IPv4Protocol] = true
+			case strings.EqualFold(value, string(v1.IPv6Protocol)):
+				families[v1.IPv6Protocol] = true
+			default:
+				return nil, fmt.Errorf("annotation %s: unknown IP family %q", annotationIPFamilies, value)
+			}
+		}
+		return families, nil
+	}
+
+	if len(service.Spec.IPFamilies) == 0 {
+		// The loadbalancer can serve both families
+		return map[v1.IPFamily]bool{v1.IPv4Protocol: true, v1.IPv6Protocol: true}, nil
+	}
+
+	families := map[v1.IPFamily]bool{}
+	for _, family := range service.Spec.IPFamilies {
+		families[family] = true
+	}
+	return families, nil
 }
 
-func getLBName(clusterName string, service *v1.Service) string {
-	return fmt.Sprintf("%s-%s-%s", clusterName, service.Namespace, service.Name)
+// getLoadBalancer returns the Load Balancer used by the Service, or nil if none exists. Existing Load Balancers are
+// referenced by annotation. All others are found by their ownership labels. Load Balancers created before the labels
+// were introduced are found by name, unless their labels show that they belong to another Service or cluster.
+func (l LoadBalancer) getLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*hcloud.LoadBalancer, error) {
+	if reference, ok := getAnnotation(service, annotationLBExisting); ok {
+		lb, _, err := l.client.LoadBalancer.Get(ctx, reference)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get existing loadbalancer %s: %w", reference, err)
+		}
+
+		// The services and targets the CCM added are recorded per loadbalancer, so only one service may use it
+		if lb != nil {
+			if err = l.checkExistingLBClaim(ctx, service, lb); err != nil {
+				return nil, err
+			}
+		}
+		return lb, nil
+	}
+
+	lbs, err := l.client.LoadBalancer.AllWithOpts(ctx, hcloud.LoadBalancerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: fmt.Sprintf("%s=%s,%s=%s", labelCluster, clusterName, labelServiceUID, service.UID),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to check for existing loadbalancer: %w", err)
+	}
+
+	switch len(lbs) {
+	case 0:
+		// Fallback to the name below
+	case 1:
+		return lbs[0], nil
+	default:
+		return nil, fmt.Errorf("found %d loadbalancers owned by the service, expected at most one", len(lbs))
+	}
+
+	lbName := l.getLBName(clusterName, service)
+	lb, _, err := l.client.LoadBalancer.GetByName(ctx, lbName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check for existing loadbalancer: %w", err)
+	}
+
+	if lb == nil {
+		return nil, nil
+	}
+
+	uid, hasUID := lb.Labels[labelServiceUID]
+	cluster, hasCluster := lb.Labels[labelCluster]
+	if (hasUID && uid != string(service.UID)) || (hasCluster && cluster != clusterName) {
+		return nil, fmt.Errorf("%w: %s", errLBOwnedByOther, lbName)
+	}
+
+	return lb, nil
+}
+
+func getLBLabels(clusterName string, service *v1.Service) map[string]string {
+	return map[string]string{
+		labelCluster:    clusterName,
+		labelServiceUID: string(service.UID),
+	}
+}
+
+// updateLBLabels applies update to a copy of the labels of the Load Balancer and saves them if they changed.
+func (l LoadBalancer) updateLBLabels(ctx context.Context, lb *hcloud.LoadBalancer, update func(labels map[string]string)) error {
+	labels := maps.Clone(lb.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	update(labels)
+
+	if maps.Equal(labels, lb.Labels) {
+		return nil
+	}
+
+	if _, _, err := l.client.LoadBalancer.Update(ctx, lb, hcloud.LoadBalancerUpdateOpts{Labels: labels}); err != nil {
+		return fmt.Errorf("unable to update labels of loadbalancer: %w", err)
+	}
+
+	lb.Labels = labels
+	return nil
+}
+
+// ensureLBNameAndLabels adds the ownership labels to Load Balancers that were found by name, and renames Load
+// Balancers whose name does not match the current name template or annotation.
+func (l LoadBalancer) ensureLBNameAndLabels(ctx context.Context, lbName, clusterName string, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	labels := maps.Clone(lb.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	labelsChanged := false
+	for key, value := range getLBLabels(clusterName, service) {
+		if labels[key] != value {
+			labels[key] = value
+			labelsChanged = true
+		}
+	}
+	if _, ok := labels[labelRetained]; ok {
+		delete(labels, labelRetained)
+		labelsChanged = true
+	}
+
+	if !labelsChanged && lb.Name == lbName {
+		return nil
+	}
+
+	if labelsChanged {
+		klog.InfoS("adopting loadbalancer", "service", klog.KObj(service), "loadBalancer", lb.Name)
+	}
+	if lb.Name != lbName {
+		klog.InfoS("renaming loadbalancer", "service", klog.KObj(service), "loadBalancer", lb.Name, "name", lbName)
+	}
+
+	_, _, err := l.client.LoadBalancer.Update(ctx, lb, hcloud.LoadBalancerUpdateOpts{Name: lbName, Labels: labels})
+	if err != nil {
+		return fmt.Errorf("unable to update name and labels of loadbalancer: %w", err)
+	}
+
+	lb.Name = lbName
+	lb.Labels = labels
+	return nil
 }
 
 func (l LoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	unlock := l.locks.lock(service.UID)
+	defer unlock()
+
 	// Get existing LoadBalancer
-	lbName := getLBName(clusterName, service)
-	lb, _, err := l.client.LoadBalancer.GetByName(ctx, lbName)
+	lb, err := l.getLoadBalancer(ctx, clusterName, service)
 	if err != nil {
-		return fmt.Errorf("unable to check for existing loadbalancer: %w", err)
+		return err
 	}
 
 	if lb == nil {
 		return fmt.Errorf("no existing loadbalancer found")
 	}
 
-	if _, err = l.updateLBTargets(ctx, nodes, lb); err != nil {
+	if err = checkReservedIPs(service, lb); err != nil {
 		return err
 	}
-	return nil
+
+	_, err = l.reconcileLB(ctx, clusterName, service, nodes, lb)
+	return err
 }
 
 func (l LoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	unlock := l.locks.lock(service.UID)
+	defer unlock()
+
 	// Get existing LoadBalancer
-	lbName := getLBName(clusterName, service)
-	lb, _, err := l.client.LoadBalancer.GetByName(ctx, lbName)
+	lb, err := l.getLoadBalancer(ctx, clusterName, service)
 	if err != nil {
-		return fmt.Errorf("unable to check for existing loadbalancer: %w", err)
+		if errors.Is(err, errLBOwnedByOther) {
+			// The loadbalancer with our name belongs to someone else, never delete it
+			klog.InfoS("not deleting loadbalancer owned by another service or cluster", "service", klog.KObj(service), "err", err)
+			return nil
+		}
+		return err
 	}
 
 	if lb == nil {
 		return nil
 	}
 
-	_, err = l.client.LoadBalancer.Delete(ctx, lb)
+	if isExistingLB(service) {
+		// Never delete loadbalancers we do not own
+		if err = l.releaseExistingLB(ctx, clusterName, service, lb); err != nil {
+			return err
+		}
+	} else if lb.Protection.Delete || l.config.Retain {
+		if err = l.retainLB(ctx, service, lb); err != nil {
+			return err
+		}
+	} else {
+		_, err = l.client.LoadBalancer.Delete(ctx, lb)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = l.cleanupManagedCertificates(ctx, service, lbServiceSettings{}); err != nil {
+		klog.ErrorS(err, "unable to clean up managed certificates", "service", klog.KObj(service))
+	}
+
+	return nil
+}
+
+// retainLB keeps a protected Load Balancer and its IPs when the Service is deleted, but removes all its services and
+// targets, so no traffic reaches the cluster anymore. The Service UID label is replaced by the retained marker, while the
+// cluster label is kept, so only a new Service in the same cluster adopts it.
+func (l LoadBalancer) retainLB(ctx context.Context, service *v1.Service, lb *hcloud.LoadBalancer) error {
+	for _, svc := range lb.Services {
+		if _, _, err := l.client.LoadBalancer.DeleteService(ctx, lb, svc.ListenPort); err != nil {
+			return fmt.Errorf("unable to delete loadbalancer service %d: %w", svc.ListenPort, err)
+		}
+	}
+
+	for _, target := range lb.Targets {
+		var err error
+		switch target.Type {
+		case hcloud.LoadBalancerTargetTypeServer:
+			_, _, err = l.client.LoadBalancer.RemoveServerTarget(ctx, lb, target.Server.Server)
+		case hcloud.LoadBalancerTargetTypeLabelSelector:
+			_, _, err = l.client.LoadBalancer.RemoveLabelSelectorTarget(ctx, lb, target.LabelSelector.Selector)
+		case hcloud.LoadBalancerTargetTypeIP:
+			_, _, err = l.client.LoadBalancer.RemoveIPTarget(ctx, lb, net.ParseIP(target.IP.IP))
+		}
+		if err != nil {
+			return fmt.Errorf("unable to remove target: %w", err)
+		}
+	}
+
+	err := l.updateLBLabels(ctx, lb, func(labels map[string]string) {
+		delete(labels, labelServiceUID)
+		labels[labelRetained] = "true"
+	})
 	if err != nil {
 		return err
 	}
 
+	klog.InfoS("retained loadbalancer", "service", klog.KObj(service), "loadBalancer", lb.Name)
 	return nil
 }
@@ -0,0 +1,91 @@
+package ccm
+
+import (
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	v1 "k8s.io/api/core/v1"
+	"reflect"
+	"testing"
+)
+
+func TestGetIPFamilies(t *testing.T) {
+	both := map[v1.IPFamily]bool{v1.IPv4Protocol: true, v1.IPv6Protocol: true}
+
+	tests := []struct {
+		name       string
+		annotation *string
+		families   []v1.IPFamily
+		policy     v1.IPFamilyPolicy
+		expected   map[v1.IPFamily]bool
+		wantErr    bool
+	}{
+		{
+			name:     "spec not set",
+			expected: both,
+		},
+		{
+			name:     "single stack",
+			families: []v1.IPFamily{v1.IPv4Protocol},
+			policy:   v1.IPFamilyPolicySingleStack,
+			expected: map[v1.IPFamily]bool{v1.IPv4Protocol: true},
+		},
+		{
+			name:     "prefer dual stack on a single stack cluster",
+			families: []v1.IPFamily{v1.IPv6Protocol},
+			policy:   v1.IPFamilyPolicyPreferDualStack,
+			expected: map[v1.IPFamily]bool{v1.IPv6Protocol: true},
+		},
+		{
+			name:     "dual stack",
+			families: []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol},
+			policy:   v1.IPFamilyPolicyRequireDualStack,
+			expected: both,
+		},
+		{
+			name:       "annotation takes precedence",
+			annotation: hcloud.Ptr("ipv6"),
+			families:   []v1.IPFamily{v1.IPv4Protocol},
+			policy:     v1.IPFamilyPolicySingleStack,
+			expected:   map[v1.IPFamily]bool{v1.IPv6Protocol: true},
+		},
+		{
+			name:       "annotation with both families",
+			annotation: hcloud.Ptr("IPv4, IPv6"),
+			expected:   both,
+		},
+		{
+			name:       "unknown family",
+			annotation: hcloud.Ptr("IPv5"),
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.annotation != nil {
+				annotations[annotationIPFamilies] = *tt.annotation
+			}
+
+			service := newAnnotatedService(annotations)
+			service.Spec.IPFamilies = tt.families
+			if tt.policy != "" {
+				service.Spec.IPFamilyPolicy = &tt.policy
+			}
+
+			families, err := getIPFamilies(service)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(families, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, families)
+			}
+		})
+	}
+}
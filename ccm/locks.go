@@ -0,0 +1,48 @@
+package ccm
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+	"sync"
+)
+
+// serviceLocks serializes the changes to the Load Balancer of a Service. The service controller and the endpoints
+// watcher both update the targets, without a lock they would add and remove the same targets concurrently.
+type serviceLocks struct {
+	mu    sync.Mutex
+	locks map[types.UID]*serviceLock
+}
+
+type serviceLock struct {
+	sync.Mutex
+	// users is the number of callers holding or waiting for the lock, it is removed from the map when it drops to 0.
+	users int
+}
+
+func newServiceLocks() *serviceLocks {
+	return &serviceLocks{locks: map[types.UID]*serviceLock{}}
+}
+
+// lock blocks until the lock of the Service is acquired and returns the function to release it.
+func (s *serviceLocks) lock(uid types.UID) (unlock func()) {
+	s.mu.Lock()
+	l, ok := s.locks[uid]
+	if !ok {
+		l = &serviceLock{}
+		s.locks[uid] = l
+	}
+	l.users++
+	s.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		s.mu.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(s.locks, uid)
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,348 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+)
+
+const (
+	targetTypeServer        = "server"
+	targetTypeLabelSelector = "label-selector"
+
+	// toBeDeletedTaint is set by the cluster autoscaler on nodes it is about to delete.
+	toBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
+)
+
+// errSharedClusterLabel is returned for annotations that select nodes per Service with target type label-selector. All
+// Services share the cluster label on the servers, so the targets can not depend on the Service.
+var errSharedClusterLabel = errors.New("not supported with target type " + targetTypeLabelSelector)
+
+func getTargetType(service *v1.Service) (string, error) {
+	value, ok := getAnnotation(service, annotationTargetType)
+	if !ok {
+		return targetTypeServer, nil
+	}
+
+	switch value {
+	case targetTypeServer, targetTypeLabelSelector:
+		return value, nil
+	default:
+		return "", fmt.Errorf("annotation %s: unsupported target type %q", annotationTargetType, value)
+	}
+}
+
+// getTargetLabelSelector returns the hcloud label selector that matches the servers of the nodes of the cluster.
+func getTargetLabelSelector(clusterName string, service *v1.Service) string {
+	selector := fmt.Sprintf("%s=%s", labelCluster, clusterName)
+	if value, ok := getAnnotation(service, annotationTargetLabelSelector); ok {
+		selector += "," + value
+	}
+	return selector
+}
+
+// isManagedLabelSelector returns whether the label selector target was added by the CCM of the cluster.
+func isManagedLabelSelector(clusterName, selector string) bool {
+	return slices.Contains(strings.Split(selector, ","), fmt.Sprintf("%s=%s", labelCluster, clusterName))
+}
+
+// updateLBLabelSelectorTarget labels the servers of the nodes and makes sure the Load Balancer has exactly one label
+// selector target for them. Label selector targets of other clusters and targets not added by the CCM are kept.
+func (l LoadBalancer) updateLBLabelSelectorTarget(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, lb *hcloud.LoadBalancer, usePrivateIP bool) error {
+	if err := l.ensureClusterServerLabels(ctx, clusterName, nodes); err != nil {
+		return err
+	}
+
+	selector := getTargetLabelSelector(clusterName, service)
+	found := false
+
+	for _, target := range lb.Targets {
+		if target.Type != hcloud.LoadBalancerTargetTypeLabelSelector || !isManagedLabelSelector(clusterName, target.LabelSelector.Selector) {
+			continue
+		}
+
+		if target.LabelSelector.Selector == selector && target.UsePrivateIP == usePrivateIP {
+			found = true
+			continue
+		}
+
+		klog.InfoS("removing stale label selector target", "service", klog.KObj(service), "selector", target.LabelSelector.Selector)
+		action, _, err := l.client.LoadBalancer.RemoveLabelSelectorTarget(ctx, lb, target.LabelSelector.Selector)
+		if err != nil {
+			return fmt.Errorf("unable to remove label selector target: %w", err)
+		}
+
+		// The same selector may be added again right away with a different use_private_ip
+		if err = waitForAction(ctx, l.client, action); err != nil {
+			return fmt.Errorf("unable to remove label selector target: %w", err)
+		}
+	}
+
+	if found {
+		return nil
+	}
+
+	_, _, err := l.client.LoadBalancer.AddLabelSelectorTarget(ctx, lb, hcloud.LoadBalancerAddLabelSelectorTargetOpts{
+		Selector:     selector,
+		UsePrivateIP: hcloud.Ptr(usePrivateIP),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add label selector target: %w", err)
+	}
+
+	return nil
+}
+
+// removeManagedLabelSelectorTargets removes all label selector targets that were added by the CCM of the cluster.
+func (l LoadBalancer) removeManagedLabelSelectorTargets(ctx context.Context, clusterName string, lb *hcloud.LoadBalancer) error {
+	for _, target := range lb.Targets {
+		if target.Type != hcloud.LoadBalancerTargetTypeLabelSelector || !isManagedLabelSelector(clusterName, target.LabelSelector.Selector) {
+			continue
+		}
+
+		if _, _, err := l.client.LoadBalancer.RemoveLabelSelectorTarget(ctx, lb, target.LabelSelector.Selector); err != nil {
+			return fmt.Errorf("unable to remove label selector target: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ensureClusterServerLabels sets the cluster label on the servers of the nodes and removes it from all other servers.
+// Only servers whose label changes are updated.
+func (l LoadBalancer) ensureClusterServerLabels(ctx context.Context, clusterName string, nodes []*v1.Node) error {
+	labeledServers, err := l.client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: fmt.Sprintf("%s=%s", labelCluster, clusterName)},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list servers of cluster: %w", err)
+	}
+
+	labeled := make(map[int64]bool, len(labeledServers))
+	for _, server := range labeledServers {
+		labeled[server.ID] = true
+	}
+
+	wanted := make(map[int64]bool, len(nodes))
+	for _, node := range nodes {
+		providerID, err := getProviderID(node)
+		if err != nil {
+			return err
+		}
+		wanted[providerID] = true
+
+		if !labeled[providerID] {
+			if err = l.setServerLabel(ctx, providerID, labelCluster, clusterName, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	for serverID := range labeled {
+		if !wanted[serverID] {
+			if err = l.setServerLabel(ctx, serverID, labelCluster, "", false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// setServerLabel sets or removes the label on the server.
+func (l LoadBalancer) setServerLabel(ctx context.Context, serverID int64, key, value string, set bool) error {
+	server, _, err := l.client.Server.GetByID(ctx, serverID)
+	if err != nil {
+		return fmt.Errorf("unable to get server %d: %w", serverID, err)
+	}
+	if server == nil {
+		// Nothing to label anymore
+		return nil
+	}
+
+	serverLabels := maps.Clone(server.Labels)
+	if serverLabels == nil {
+		serverLabels = map[string]string{}
+	}
+	if set {
+		serverLabels[key] = value
+	} else {
+		delete(serverLabels, key)
+	}
+
+	if _, _, err = l.client.Server.Update(ctx, server, hcloud.ServerUpdateOpts{Labels: serverLabels}); err != nil {
+		return fmt.Errorf("unable to update labels of server %d: %w", serverID, err)
+	}
+	return nil
+}
+
+// filterNodes returns the nodes that match the node selector of the Service. Nodes with the label
+// node.kubernetes.io/exclude-from-external-load-balancers are always excluded, so single nodes can opt out without
+// changing the Service.
+func filterNodes(service *v1.Service, nodes []*v1.Node, targetType string) ([]*v1.Node, error) {
+	selector := labels.Everything()
+	if value, ok := getAnnotation(service, annotationNodeSelector); ok {
+		if targetType == targetTypeLabelSelector {
+			return nil, fmt.Errorf("annotation %s: %w, use %s instead", annotationNodeSelector, errSharedClusterLabel, annotationTargetLabelSelector)
+		}
+
+		var err error
+		if selector, err = labels.Parse(value); err != nil {
+			return nil, fmt.Errorf("annotation %s: unable to parse label selector: %w", annotationNodeSelector, err)
+		}
+	}
+
+	filtered := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if _, excluded := node.Labels[v1.LabelNodeExcludeBalancers]; excluded {
+			continue
+		}
+		if !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+
+	return filtered, nil
+}
+
+// isTargetableNode mirrors the node predicates of the service controller, so the endpoints watcher passes the same
+// nodes to updateLBTargets. The service controller does not filter on readiness, the health checks of the Load
+// Balancer take nodes that are not ready out of rotation.
+func isTargetableNode(node *v1.Node) bool {
+	if !node.DeletionTimestamp.IsZero() {
+		return false
+	}
+	if _, excluded := node.Labels[v1.LabelNodeExcludeBalancers]; excluded {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == toBeDeletedTaint {
+			return false
+		}
+	}
+	return true
+}
+
+// splitNodes separates the hcloud servers from the other nodes, which are added as IP targets. Nodes that can not be
+// targeted are skipped with a warning event on the Service.
+func (l LoadBalancer) splitNodes(service *v1.Service, nodes []*v1.Node) (serverNodes []*v1.Node, ips []net.IP) {
+	for _, node := range nodes {
+		switch {
+		case node.Spec.ProviderID == "":
+			// Nodes of hcloud servers have no ProviderID until they are initialized
+			l.warnf(service, eventReasonNodeNotTargeted, "Node %s is not added as target: node has no ProviderID", node.Name)
+
+		case strings.HasPrefix(node.Spec.ProviderID, providerName+"://"):
+			if _, err := getProviderID(node); err != nil {
+				l.warnf(service, eventReasonNodeNotTargeted, "Node %s is not added as target: %v", node.Name, err)
+				continue
+			}
+			serverNodes = append(serverNodes, node)
+
+		default:
+			ip := getNodeIP(node, l.networkID != 0)
+			if ip == nil {
+				l.warnf(service, eventReasonNodeNotTargeted, "Node %s is not added as target: node is not an hcloud server and has no InternalIP or ExternalIP", node.Name)
+				continue
+			}
+			ips = append(ips, ip)
+		}
+	}
+
+	return serverNodes, ips
+}
+
+// getNodeIP returns the IP of a node that is not an hcloud server. If the cluster uses a private network, the
+// InternalIP is preferred, as it is usually in a vSwitch subnet of the network. Otherwise the ExternalIP is preferred.
+func getNodeIP(node *v1.Node, preferInternal bool) net.IP {
+	addressTypes := []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP}
+	if preferInternal {
+		slices.Reverse(addressTypes)
+	}
+
+	for _, addressType := range addressTypes {
+		for _, address := range node.Status.Addresses {
+			if address.Type != addressType {
+				continue
+			}
+			if ip := net.ParseIP(address.Address); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	return nil
+}
+
+// updateLBIPTargets adds the IPs as targets to the Load Balancer and removes all other IP targets. state is the record of
+// an existing Load Balancer, only IP targets recorded there are removed. IPs the API rejects are skipped with a warning
+// event.
+func (l LoadBalancer) updateLBIPTargets(ctx context.Context, service *v1.Service, ips []net.IP, lb *hcloud.LoadBalancer, state *existingLBState) error {
+	wanted := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		wanted[ip.String()] = true
+	}
+
+	current := map[string]bool{}
+	for _, target := range lb.Targets {
+		if target.Type != hcloud.LoadBalancerTargetTypeIP {
+			continue
+		}
+
+		ip := net.ParseIP(target.IP.IP)
+		if ip == nil {
+			continue
+		}
+		current[ip.String()] = true
+
+		if wanted[ip.String()] || (state != nil && !state.hasIP(ip)) {
+			continue
+		}
+
+		if _, _, err := l.client.LoadBalancer.RemoveIPTarget(ctx, lb, ip); err != nil {
+			return fmt.Errorf("unable to remove IP target %s: %w", ip, err)
+		}
+
+		if state != nil {
+			state.setIP(ip, false)
+			if err := l.saveExistingLBState(ctx, service, state); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, ip := range ips {
+		if current[ip.String()] {
+			continue
+		}
+
+		_, _, err := l.client.LoadBalancer.AddIPTarget(ctx, lb, hcloud.LoadBalancerAddIPTargetOpts{IP: ip})
+		if err != nil {
+			var apiErr hcloud.Error
+			if errors.As(err, &apiErr) {
+				l.warnf(service, eventReasonNodeNotTargeted, "IP %s is not added as target: %v", ip, err)
+				continue
+			}
+			return fmt.Errorf("unable to add IP target %s: %w", ip, err)
+		}
+		current[ip.String()] = true
+
+		if state != nil {
+			state.setIP(ip, true)
+			if err = l.saveExistingLBState(ctx, service, state); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -110,4 +110,216 @@ func TestServiceWorks(t *testing.T) {
 	}
 }
 
-// TODO: Tests for: Removing+Updating Ports, Removing Targets, Removing LBs,
+func TestServicePortsUpdated(t *testing.T) {
+	ctx := context.Background()
+
+	createNginxDeployment(t, "service-ports")
+
+	svcClient := client.CoreV1().Services(namespace)
+
+	_, err := svcClient.Create(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "service-ports",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeLoadBalancer,
+			Selector: map[string]string{"app": "service-ports"},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "web",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromString("web"),
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	svc := waitForLoadBalancer(t, "service-ports", func(svc *corev1.Service) error { return nil })
+
+	// Move the service to another port, the CCM updates the status concurrently
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := svcClient.Get(ctx, "service-ports", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		current.Spec.Ports[0].Port = 8080
+		_, err = svcClient.Update(ctx, current, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to update service: %v", err)
+	}
+
+	ip := getPublicIPv4(t, svc)
+	err = retry.OnError(backoff, alwaysRetry, func() error {
+		resp, err := http.Get(fmt.Sprintf("http://%s:8080", ip.String()))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to wait for updated port: %v", err)
+	}
+}
+
+func TestServicePrivateOnly(t *testing.T) {
+	ctx := context.Background()
+
+	createNginxDeployment(t, "service-private")
+
+	_, err := client.CoreV1().Services(namespace).Create(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "service-private",
+			Annotations: map[string]string{
+				"load-balancer.hetzner.cloud/disable-public-network": "true",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeLoadBalancer,
+			Selector: map[string]string{"app": "service-private"},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "web",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromString("web"),
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	svc := waitForLoadBalancer(t, "service-private", func(svc *corev1.Service) error { return nil })
+
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ip := net.ParseIP(ingress.IP); ip == nil || !ip.IsPrivate() {
+			t.Errorf("expected only private ingress IPs, got %s", ingress.IP)
+		}
+	}
+}
+
+func TestServicePrivateOnlyWithPrivateIP(t *testing.T) {
+	ctx := context.Background()
+
+	createNginxDeployment(t, "service-private-ip")
+
+	_, err := client.CoreV1().Services(namespace).Create(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "service-private-ip",
+			Annotations: map[string]string{
+				"load-balancer.hetzner.cloud/disable-public-network": "true",
+				"load-balancer.hetzner.cloud/private-ipv4":           "10.0.0.200",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeLoadBalancer,
+			Selector: map[string]string{"app": "service-private-ip"},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "web",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromString("web"),
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	svc := waitForLoadBalancer(t, "service-private-ip", func(svc *corev1.Service) error { return nil })
+
+	if len(svc.Status.LoadBalancer.Ingress) != 1 || svc.Status.LoadBalancer.Ingress[0].IP != "10.0.0.200" {
+		t.Errorf("expected only the ingress IP 10.0.0.200, got %v", svc.Status.LoadBalancer.Ingress)
+	}
+}
+
+// TODO: Tests for: Removing Targets, Removing LBs,
+
+func createNginxDeployment(t *testing.T, name string) {
+	t.Helper()
+
+	_, err := client.AppsV1().Deployments(namespace).Create(context.Background(), &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"app": name},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nginx",
+							Image: "nginx:stable",
+							Ports: []corev1.ContainerPort{{ContainerPort: 80, Name: "web"}},
+						},
+					},
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create deployment: %v", err)
+	}
+}
+
+// waitForLoadBalancer waits until the Service has ingress endpoints and check returns no error.
+func waitForLoadBalancer(t *testing.T, name string, check func(svc *corev1.Service) error) *corev1.Service {
+	t.Helper()
+
+	// Wait for LB to become available
+	var svc *corev1.Service
+	err := retry.OnError(backoff, alwaysRetry, func() error {
+		var err error
+		svc, err = client.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if len(svc.Status.LoadBalancer.Ingress) == 0 {
+			return fmt.Errorf("no ingress endpoints set yet")
+		}
+
+		return check(svc)
+	})
+	if err != nil {
+		t.Fatalf("failed to wait for service to become available: %v", err)
+	}
+
+	return svc
+}
+
+func getPublicIPv4(t *testing.T, svc *corev1.Service) net.IP {
+	t.Helper()
+
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		// Find public IPv4
+		ip := net.ParseIP(ingress.IP).To4()
+		if ip != nil && !ip.IsPrivate() {
+			return ip
+		}
+	}
+
+	t.Fatalf("no public ipv4 available")
+	return nil
+}